@@ -2,18 +2,24 @@ package client
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"example.com/game/client/protocol"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-type Message struct {
-	Type    string
-	Payload map[string]interface{}
-}
+type Message = protocol.Message
 
 var result = make(chan string)
 
@@ -34,17 +40,97 @@ type State interface {
 	Execute(client *Client)
 }
 
-type IdleState struct{}
+type IdleState struct {
+	prompted bool
+}
 
 func (s *IdleState) Execute(client *Client) {
-	fmt.Println("Type \"play\" or \"quit\"")
+	if !s.prompted {
+		fmt.Println("Type \"play\", \"play <queue>\", \"queues\", \"profile\", \"party\" or \"quit\"")
+		fmt.Println("Party commands: \"party\", \"invite <player>\", \"join <party>\", \"leave\", \"kick <player>\", \"promote <player>\"")
+		fmt.Println("Lobby commands: \"host\", \"enter <code>\", \"start\", \"exit\"")
+		s.prompted = true
+	}
+
+	var line string
+
+	select {
+	case line = <-ReadInput():
+	case msg := <-client.Incoming:
+		s.handle(client, msg)
+		return
+	}
 
-	switch <-ReadInput() {
+	input := strings.Fields(line)
+	command, argument := "", ""
+	if len(input) > 0 {
+		command = input[0]
+	}
+	if len(input) > 1 {
+		argument = input[1]
+	}
+
+	switch command {
 	case "play":
 		client.Send(Message{
 			Type: "queue_up",
+			Payload: protocol.QueueUp{
+				Queue: argument,
+			},
 		})
 		client.SetState(&WaitingForMatch{})
+	case "queues":
+		client.Send(Message{
+			Type: "list_queues",
+		})
+		client.SetState(&QueuesState{})
+	case "profile":
+		client.Send(Message{
+			Type: "profile",
+		})
+		client.SetState(&ProfileState{})
+	case "party":
+		client.Send(Message{
+			Type: "create_party",
+		})
+	case "invite", "kick", "promote":
+		client.Send(Message{
+			Type: command + "_player",
+			Payload: protocol.PartyAction{
+				PlayerId: argument,
+			},
+		})
+	case "join":
+		client.Send(Message{
+			Type: "join_party",
+			Payload: protocol.JoinParty{
+				PartyId: argument,
+			},
+		})
+	case "leave":
+		client.Send(Message{
+			Type: "leave_party",
+		})
+	case "host":
+		client.Send(Message{
+			Type:    "create_lobby",
+			Payload: protocol.CreateLobby{},
+		})
+	case "enter":
+		client.Send(Message{
+			Type: "join_lobby",
+			Payload: protocol.JoinLobby{
+				Code: argument,
+			},
+		})
+	case "start":
+		client.Send(Message{
+			Type: "start_lobby",
+		})
+	case "exit":
+		client.Send(Message{
+			Type: "leave_lobby",
+		})
 	case "quit":
 		client.Close()
 	default:
@@ -52,6 +138,77 @@ func (s *IdleState) Execute(client *Client) {
 	}
 }
 
+// Party and lobby updates, the queue the party leader joined and the game
+// the lobby host started arrive while idle
+func (s *IdleState) handle(client *Client, msg Message) {
+	switch msg.Type {
+	case "party":
+		PrintParty(msg.Payload.(*protocol.Party), client.PlayerId)
+	case "party_invite":
+		invite := msg.Payload.(*protocol.PartyInvite)
+		fmt.Printf("Player %s invited you to their party, type \"join %s\" to join\n", invite.From, invite.PartyId)
+	case "left_party":
+		if msg.Payload.(*protocol.LeftParty).Kicked {
+			fmt.Println("You were kicked from the party")
+		} else {
+			fmt.Println("You left the party")
+		}
+	case "wait_for_match":
+		fmt.Println("Your party leader queued up, type \"cancel\" to leave")
+		client.SetState(&WaitingForMatch{})
+	case "lobby":
+		PrintLobby(msg.Payload.(*protocol.Lobby), client.PlayerId)
+	case "left_lobby":
+		fmt.Println("You left the lobby")
+	case "guess":
+		StartPlaying(client, msg.Payload.(*protocol.GameStart))
+	case "disconnected":
+		client.SetState(&IdleState{})
+	case "server_shutting_down":
+		PrintShutdown(msg)
+	case "error":
+		fmt.Println(msg.Payload.(*protocol.Error).Message)
+	}
+}
+
+// Waits for the rating the player asked for
+type ProfileState struct{}
+
+func (s *ProfileState) Execute(client *Client) {
+	msg := <-client.Incoming
+
+	switch msg.Type {
+	case "profile":
+		profile := msg.Payload.(*protocol.Profile)
+		fmt.Printf("Player ID: %s\n", client.PlayerId)
+		fmt.Printf("Rating: %.0f ± %.0f after %d games\n", profile.Rating, 2*profile.Deviation, profile.Games)
+		client.SetState(&IdleState{})
+	case "disconnected":
+		client.SetState(&IdleState{})
+	case "server_shutting_down":
+		PrintShutdown(msg)
+		client.SetState(&IdleState{})
+	}
+}
+
+// Waits for the queues the player asked for
+type QueuesState struct{}
+
+func (s *QueuesState) Execute(client *Client) {
+	msg := <-client.Incoming
+
+	switch msg.Type {
+	case "queues":
+		PrintQueues(msg.Payload.(*protocol.Queues))
+		client.SetState(&IdleState{})
+	case "disconnected":
+		client.SetState(&IdleState{})
+	case "server_shutting_down":
+		PrintShutdown(msg)
+		client.SetState(&IdleState{})
+	}
+}
+
 type WaitingForMatch struct{}
 
 func (s *WaitingForMatch) Execute(client *Client) {
@@ -68,11 +225,25 @@ func (s *WaitingForMatch) Execute(client *Client) {
 		switch msg.Type {
 		case "wait_for_match":
 			fmt.Println("Waiting for match... type \"cancel\" to leave")
+		case "queue_status":
+			PrintQueueStatus(msg.Payload.(*protocol.QueueStatus))
+		case "dequeued":
+			fmt.Println(msg.Payload.(*protocol.Dequeued).Message)
+			client.SetState(&IdleState{})
+		case "party", "party_invite", "left_party":
+			(&IdleState{}).handle(client, msg)
 		case "match_found":
-			matchId := int(msg.Payload["matchId"].(float64))
 			client.SetState(&MatchFoundState{
-				MatchId: matchId,
+				MatchId: msg.Payload.(*protocol.MatchFound).MatchId,
 			})
+		case "disconnected":
+			client.SetState(&IdleState{})
+		case "server_shutting_down":
+			PrintShutdown(msg)
+			client.SetState(&IdleState{})
+		case "error":
+			fmt.Println(msg.Payload.(*protocol.Error).Message)
+			client.SetState(&IdleState{})
 		default:
 			fmt.Println("weird type", msg)
 		}
@@ -80,7 +251,7 @@ func (s *WaitingForMatch) Execute(client *Client) {
 }
 
 type MatchFoundState struct {
-	MatchId int
+	MatchId string
 }
 
 func (s *MatchFoundState) Execute(client *Client) {
@@ -92,6 +263,11 @@ func (s *MatchFoundState) Execute(client *Client) {
 		case "match_canceled":
 			fmt.Println("Match canceled")
 			client.SetState(&IdleState{})
+		case "disconnected":
+			client.SetState(&IdleState{})
+		case "server_shutting_down":
+			PrintShutdown(msg)
+			client.SetState(&IdleState{})
 		default:
 			fmt.Println("nope", msg)
 		}
@@ -100,16 +276,16 @@ func (s *MatchFoundState) Execute(client *Client) {
 		case "accept":
 			client.Send(Message{
 				Type: "match_confirmed",
-				Payload: map[string]interface{}{
-					"matchId": s.MatchId,
+				Payload: protocol.MatchConfirmed{
+					MatchId: s.MatchId,
 				},
 			})
 			client.SetState(&MatchConfirmedState{})
 		case "decline":
 			client.Send(Message{
 				Type: "match_declined",
-				Payload: map[string]interface{}{
-					"matchId": s.MatchId,
+				Payload: protocol.MatchDeclined{
+					MatchId: s.MatchId,
 				},
 			})
 			client.SetState(&IdleState{})
@@ -130,49 +306,423 @@ func (s *MatchConfirmedState) Execute(client *Client) {
 	case "match_canceled":
 		fmt.Println("Match canceled")
 		client.SetState(&WaitingForMatch{})
+	case "error":
+		fmt.Println(msg.Payload.(*protocol.Error).Message)
+		client.SetState(&IdleState{})
+	case "disconnected":
+		client.SetState(&IdleState{})
+	case "server_shutting_down":
+		PrintShutdown(msg)
 	case "guess":
-		fmt.Println("Guess a number")
-		client.SetState(&PlayingState{
-			GameId: int(msg.Payload["GameId"].(float64)),
-		})
+		StartPlaying(client, msg.Payload.(*protocol.GameStart))
+	}
+}
+
+func StartPlaying(client *Client, start *protocol.GameStart) {
+	if start.Players > 2 {
+		fmt.Printf("You are player %d of %d\n", start.Player, start.Players)
 	}
+	PrintRules(start.Rules)
+
+	client.SetState(&PlayingState{
+		GameId: start.GameId,
+		Player: start.Player,
+		Rules:  start.Rules,
+	})
 }
 
 type PlayingState struct {
-	GameId int
+	GameId string
+	Player int
+	Rules  protocol.GameRules
+
+	// whether input is the player's secret rather than a guess, in duels
+	Choosing bool
+	// whether another player offered a draw
+	DrawOffered bool
 }
 
 func (s *PlayingState) Execute(client *Client) {
 	select {
-	case guess := <-ReadInput():
+	case input := <-ReadInput():
+		switch strings.TrimSpace(input) {
+		case "forfeit":
+			client.Send(Message{
+				Type:    "forfeit",
+				Payload: protocol.GameAction{GameId: s.GameId},
+			})
+			return
+		case "draw":
+			action := "offer_draw"
+			if s.DrawOffered {
+				action = "accept_draw"
+			}
+
+			client.Send(Message{
+				Type:    action,
+				Payload: protocol.GameAction{GameId: s.GameId},
+			})
+			return
+		}
+
+		guess, err := strconv.Atoi(strings.TrimSpace(input))
+
+		if err != nil {
+			fmt.Println("Type a number, \"draw\" or \"forfeit\"")
+			return
+		}
+
+		if s.Choosing {
+			client.Send(Message{
+				Type: "secret",
+				Payload: protocol.Secret{
+					Secret: guess,
+					GameId: s.GameId,
+				},
+			})
+			return
+		}
+
 		client.Send(Message{
 			Type: "guess",
-			Payload: map[string]interface{}{
-				"guess":  guess,
-				"gameId": s.GameId,
+			Payload: protocol.Guess{
+				Guess:  guess,
+				GameId: s.GameId,
 			},
 		})
 	case msg := <-client.Incoming:
 		switch msg.Type {
 		case "feedback":
-			fmt.Println(msg.Payload["message"].(string))
+			feedback := msg.Payload.(*protocol.Feedback)
+
+			if feedback.Remaining >= 0 {
+				fmt.Printf("%s (%d guesses left)\n", feedback.Message, feedback.Remaining)
+			} else {
+				fmt.Println(feedback.Message)
+			}
+		case "out_of_guesses":
+			fmt.Println(msg.Payload.(*protocol.OutOfGuesses).Message)
+		case "choose_secret":
+			left := time.Until(msg.Payload.(*protocol.ChooseSecret).Deadline).Round(time.Second)
+			fmt.Printf("Type your secret, you have %s\n", left)
+			s.Choosing = true
+		case "secrets_chosen":
+			chosen := msg.Payload.(*protocol.SecretsChosen)
+			s.Choosing = false
+
+			if chosen.Drawn {
+				fmt.Printf("Time is up, your secret is %s\n", s.Rules.Format(chosen.Secret))
+			}
+			fmt.Printf("Now guess player %d's secret\n", chosen.Opponent)
+		case "turn":
+			turn := msg.Payload.(*protocol.Turn)
+			left := time.Until(turn.Deadline).Round(time.Second)
+
+			if turn.Player == s.Player {
+				fmt.Printf("Your turn, %s to guess\n", left)
+			} else {
+				fmt.Printf("Player %d's turn\n", turn.Player)
+			}
+		case "opponent_guess":
+			guess := msg.Payload.(*protocol.OpponentGuess)
+			fmt.Printf("Player %d guessed %s: %s\n", guess.Player, s.Rules.Format(guess.Guess), guess.Message)
+		case "turn_missed":
+			missed := msg.Payload.(*protocol.TurnMissed)
+			who := fmt.Sprintf("Player %d", missed.Player)
+			if missed.Player == s.Player {
+				who = "You"
+			}
+
+			if missed.Forfeited {
+				fmt.Printf("%s missed too many turns and forfeited\n", who)
+			} else {
+				fmt.Printf("%s missed the turn\n", who)
+			}
+		case "player_forfeited":
+			forfeited := msg.Payload.(*protocol.PlayerForfeited)
+
+			if forfeited.Left {
+				fmt.Printf("Player %d left the game\n", forfeited.Player)
+			} else {
+				fmt.Printf("Player %d forfeited\n", forfeited.Player)
+			}
+		case "draw_offered":
+			s.DrawOffered = true
+			fmt.Printf("Player %d offers a draw, type \"draw\" to accept\n", msg.Payload.(*protocol.DrawOffered).Player)
+		case "finished":
+			fmt.Println(msg.Payload.(*protocol.Finished).Message)
+		case "draw":
+			fmt.Println(msg.Payload.(*protocol.Draw).Message)
 		case "victory":
-			fmt.Println(msg.Payload["message"].(string))
-			client.SetState(&IdleState{})
+			fmt.Println(msg.Payload.(*protocol.Victory).Message)
 		case "loss":
-			fmt.Println(msg.Payload["message"].(string))
+			fmt.Println(msg.Payload.(*protocol.Loss).Message)
+		case "standings":
+			PrintStandings(msg.Payload.(*protocol.Standings))
+			client.SetState(&GameOverState{Player: s.Player})
+		case "error":
+			fmt.Println(msg.Payload.(*protocol.Error).Message)
+		case "disconnected":
 			client.SetState(&IdleState{})
+		case "server_shutting_down":
+			PrintShutdown(msg)
+		case "game_aborted":
+			fmt.Println(msg.Payload.(*protocol.GameAborted).Message)
+			client.SetState(&IdleState{})
+		}
+	}
+}
+
+// GameOverState waits for the next round of a series or a rematch offer
+type GameOverState struct {
+	Player    int
+	RematchId string
+}
+
+func (s *GameOverState) Execute(client *Client) {
+	select {
+	case input := <-ReadInput():
+		switch strings.TrimSpace(input) {
+		case "rematch":
+			if s.RematchId == "" {
+				fmt.Println("No rematch was offered")
+				return
+			}
+
+			client.Send(Message{
+				Type: "rematch_accepted",
+				Payload: protocol.RematchAccepted{
+					RematchId: s.RematchId,
+				},
+			})
+			fmt.Println("Waiting for the other players...")
+		case "leave":
+			if s.RematchId != "" {
+				client.Send(Message{
+					Type: "rematch_declined",
+					Payload: protocol.RematchDeclined{
+						RematchId: s.RematchId,
+					},
+				})
+			}
+			client.SetState(&IdleState{})
+		}
+	case msg := <-client.Incoming:
+		switch msg.Type {
+		case "series_score":
+			PrintSeries(msg.Payload.(*protocol.Series), s.Player)
+		case "guess":
+			StartPlaying(client, msg.Payload.(*protocol.GameStart))
+		case "rematch_offer":
+			offer := msg.Payload.(*protocol.RematchOffer)
+			s.RematchId = offer.RematchId
+
+			left := time.Until(offer.Deadline).Round(time.Second)
+			fmt.Printf("Type \"rematch\" within %s to play again or \"leave\"\n", left)
+		case "rematch_canceled":
+			fmt.Println("No rematch")
+			client.SetState(&IdleState{})
+		case "error":
+			fmt.Println(msg.Payload.(*protocol.Error).Message)
+		case "disconnected":
+			client.SetState(&IdleState{})
+		case "server_shutting_down":
+			PrintShutdown(msg)
+			client.SetState(&IdleState{})
+		}
+	}
+}
+
+func PrintSeries(series *protocol.Series, you int) {
+	fmt.Printf("Round %d of best of %d:", series.Round, series.BestOf)
+
+	for _, score := range series.Scores {
+		if score.Player == you {
+			fmt.Printf(" you %d", score.Wins)
+		} else {
+			fmt.Printf(" player %d %d", score.Player, score.Wins)
+		}
+	}
+	fmt.Println()
+
+	switch {
+	case !series.Over:
+		fmt.Println("Next round!")
+	case series.Winner == you:
+		fmt.Println("You won the series!")
+	case series.Winner == 0:
+		fmt.Println("The series is tied")
+	default:
+		fmt.Printf("Player %d won the series\n", series.Winner)
+	}
+}
+
+func PrintRules(rules protocol.GameRules) {
+	if rules.BullsAndCows() {
+		fmt.Printf("Guess the code of %d different digits\n", rules.Digits)
+		fmt.Println("Bulls are right digits in the right place, cows are right digits in the wrong place")
+	} else {
+		fmt.Printf("Guess a number between %d and %d\n", rules.Min, rules.Max)
+	}
+
+	if rules.MaxGuesses > 0 {
+		fmt.Printf("You have %d guesses\n", rules.MaxGuesses)
+	}
+	if rules.TimeLimit > 0 {
+		fmt.Printf("The game ends in a draw after %s\n", rules.TimeLimit)
+	}
+	if !rules.SharedAnswer {
+		fmt.Println("Each player has their own number")
+	}
+	if rules.KeepPlaying {
+		fmt.Println("The game goes on until everyone guesses it")
+	}
+	if rules.TurnBased() {
+		fmt.Printf("Players take turns, %s each\n", rules.TurnTime)
+	}
+	if rules.Duel {
+		fmt.Println("Each player picks the secret their opponent guesses")
+	}
+}
+
+func PrintParty(party *protocol.Party, you string) {
+	fmt.Printf("Party %s:\n", party.PartyId)
+
+	for _, member := range party.Members {
+		line := "  " + member
+		if member == party.Leader {
+			line += " (leader)"
+		}
+		if member == you {
+			line += " (you)"
+		}
+		fmt.Println(line)
+	}
+}
+
+func PrintLobby(lobby *protocol.Lobby, you string) {
+	fmt.Printf("Lobby %s, %d of %d players:\n", lobby.Code, len(lobby.Players), lobby.MaxPlayers)
+
+	for _, player := range lobby.Players {
+		line := "  " + player
+		if player == lobby.Host {
+			line += " (host)"
+		}
+		if player == you {
+			line += " (you)"
+		}
+		fmt.Println(line)
+	}
+
+	if lobby.Host == you {
+		fmt.Printf("Others join with \"enter %s\", type \"start\" once everyone's in\n", lobby.Code)
+	}
+}
+
+func PrintQueues(queues *protocol.Queues) {
+	fmt.Println("Queues:")
+
+	for _, queue := range queues.Queues {
+		details := fmt.Sprintf("%d players, %s", queue.Players, queue.Rules.Variant)
+		if queue.Rules.TurnBased() {
+			details += ", turn-based"
+		}
+		if queue.Ranked {
+			details += ", ranked"
+		}
+		if queue.Name == queues.Default {
+			details += ", default"
+		}
+
+		fmt.Printf("  %s (%s): %d waiting\n", queue.Name, details, queue.Waiting)
+	}
+}
+
+func PrintQueueStatus(status *protocol.QueueStatus) {
+	wait := "unknown"
+	if status.EstimatedWait > 0 {
+		wait = "about " + status.EstimatedWait.Round(time.Second).String()
+	}
+
+	fmt.Printf("%d of %d waiting in %s, estimated wait %s\n", status.Position, status.Size, status.Queue, wait)
+}
+
+func PrintStandings(standings *protocol.Standings) {
+	fmt.Println("Standings:")
+
+	for _, standing := range standings.Standings {
+		player := fmt.Sprintf("Player %d", standing.Player)
+		if standing.Player == standings.You {
+			player = "You"
+		}
+
+		if standing.Solved {
+			fmt.Printf("  %s. %s, %d guesses\n", protocol.Ordinal(standing.Place), player, standing.Guesses)
+		} else {
+			fmt.Printf("  %s. %s, didn't guess it\n", protocol.Ordinal(standing.Place), player)
 		}
 	}
 }
 
+func PrintShutdown(msg Message) {
+	deadline := msg.Payload.(*protocol.ServerShuttingDown).Deadline
+	fmt.Printf("The server is shutting down at %s\n", deadline.Local().Format("15:04:05"))
+}
+
+// Builds the TLS config to reach a server. caFile optionally adds a PEM
+// bundle of trusted authorities, e.g. a server's self-signed certificate,
+// to the system ones. insecure skips verification altogether and is meant
+// for development only.
+func NewTLSConfig(caFile string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecure,
+	}
+
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + caFile)
+	}
+
+	config.RootCAs = pool
+	return config, nil
+}
+
+const (
+	RECONNECT_ATTEMPTS = 5
+	RECONNECT_DELAY    = time.Second
+)
+
 type Client struct {
 	state  State
 	mutex  *sync.Mutex
 	socket *websocket.Conn
 
-	Id       uuid.UUID
-	Running  bool
+	addr        string
+	session     string
+	socketMutex *sync.Mutex
+	// set to 0 by Close, read by the loop and the reader
+	running int32
+
+	Id uuid.UUID
+	// what other players invite us to parties with
+	PlayerId  string
+	Keepalive protocol.Keepalive
+	// Connects with wss:// when set
+	TLS      *tls.Config
 	Outgoing chan Message
 	Incoming chan Message
 }
@@ -182,10 +732,13 @@ func NewClient() *Client {
 		mutex: new(sync.Mutex),
 		state: &IdleState{},
 
-		Id:       uuid.New(),
-		Running:  true,
-		Outgoing: make(chan Message),
-		Incoming: make(chan Message),
+		socketMutex: new(sync.Mutex),
+		running:     1,
+
+		Id:        uuid.New(),
+		Keepalive: protocol.DefaultKeepalive(),
+		Outgoing:  make(chan Message),
+		Incoming:  make(chan Message),
 	}
 }
 
@@ -193,48 +746,194 @@ func (c *Client) SetState(state State) {
 	c.state = state
 }
 
+// Tells whether the client was not closed yet
+func (c *Client) Running() bool {
+	return atomic.LoadInt32(&c.running) == 1
+}
+
 func (c *Client) Loop() {
-	for c.Running {
+	for c.Running() {
 		c.state.Execute(c)
 	}
 }
 
 func (c *Client) Connect(addr string) error {
-	socket, _, err := websocket.DefaultDialer.Dial("ws://"+addr, nil)
+	c.addr = addr
+	socket, err := c.dial()
 
 	if err != nil {
 		return err
 	}
 
+	go c.read(socket)
+	go c.write()
+
+	return nil
+}
+
+// Opens a connection, resuming the current session if there is one
+func (c *Client) dial() (*websocket.Conn, error) {
+	c.socketMutex.Lock()
+	defer c.socketMutex.Unlock()
+
+	addr := "ws://" + c.addr
+	if c.TLS != nil {
+		addr = "wss://" + c.addr
+	}
+
+	if c.session != "" {
+		addr += "/?session=" + url.QueryEscape(c.session)
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = c.TLS
+
+	socket, _, err := dialer.Dial(addr, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keepalive := c.Keepalive
+
+	socket.SetReadDeadline(keepalive.ReadDeadline())
+	socket.SetPongHandler(func(string) error {
+		return socket.SetReadDeadline(keepalive.ReadDeadline())
+	})
+	socket.SetPingHandler(func(data string) error {
+		socket.SetReadDeadline(keepalive.ReadDeadline())
+		return socket.WriteControl(websocket.PongMessage, []byte(data), keepalive.WriteDeadline())
+	})
+
 	c.socket = socket
+	return socket, nil
+}
 
-	go func() {
-		defer socket.Close()
+// Pings the server until done is closed
+func (c *Client) heartbeat(socket *websocket.Conn, done chan bool) {
+	ticker := time.NewTicker(c.Keepalive.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			socket.WriteControl(websocket.PingMessage, nil, c.Keepalive.WriteDeadline())
+		case <-done:
+			return
+		}
+	}
+}
+
+// Tries to resume the session after the connection dropped
+func (c *Client) reconnect() *websocket.Conn {
+	c.socketMutex.Lock()
+	session := c.session
+	c.socketMutex.Unlock()
+
+	if session == "" {
+		return nil
+	}
+
+	for i := 0; i < RECONNECT_ATTEMPTS && c.Running(); i++ {
+		time.Sleep(RECONNECT_DELAY)
+
+		if socket, err := c.dial(); err == nil {
+			return socket
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) read(socket *websocket.Conn) {
+	for socket != nil {
+		socket = c.readFrom(socket)
+	}
+}
+
+// Reads from socket until it fails, returning the resumed connection
+// to read from next, if any
+func (c *Client) readFrom(socket *websocket.Conn) *websocket.Conn {
+	done := make(chan bool)
+	defer close(done)
+
+	go c.heartbeat(socket, done)
 
-		go func() {
-			for {
-				var response Message
-				err := socket.ReadJSON(&response)
+	for {
+		_, data, err := socket.ReadMessage()
 
-				if err != nil {
-					c.Close()
-					break
-				}
+		if err != nil {
+			if !c.Running() {
+				return nil
+			}
+
+			// the server closed the session on purpose, there's nothing to resume
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				fmt.Println("The server closed the connection")
+				c.Close()
+				return nil
+			}
 
-				c.Incoming <- response
+			if resumed := c.reconnect(); resumed != nil {
+				return resumed
 			}
-		}()
 
-		for {
+			fmt.Println("Lost connection to the server")
+			c.Close()
+
+			// let the current state know, if it is listening
 			select {
-			case msg := <-c.Outgoing:
-				socket.WriteJSON(msg)
+			case c.Incoming <- Message{Type: "disconnected"}:
+			default:
 			}
+
+			return nil
 		}
 
-	}()
+		socket.SetReadDeadline(c.Keepalive.ReadDeadline())
+		response, err := protocol.Decode(data, protocol.ToClient)
 
-	return nil
+		if err != nil {
+			fmt.Println("Unexpected message from server:", err)
+			continue
+		}
+
+		if response.Type == "session" {
+			// the server no longer knew us, whatever we were doing is gone
+			if !c.handleSession(response.Payload.(*protocol.Session)) {
+				fmt.Println("Connection lost, starting over")
+				c.Incoming <- Message{Type: "disconnected"}
+			}
+			continue
+		}
+
+		c.Incoming <- response
+	}
+}
+
+func (c *Client) write() {
+	for msg := range c.Outgoing {
+		c.socketMutex.Lock()
+		socket := c.socket
+		c.socketMutex.Unlock()
+
+		socket.SetWriteDeadline(c.Keepalive.WriteDeadline())
+		socket.WriteJSON(msg)
+	}
+}
+
+// Keeps the session's token for reconnecting. Returns false if it replaced
+// a session the server no longer knew.
+func (c *Client) handleSession(session *protocol.Session) bool {
+	c.socketMutex.Lock()
+	defer c.socketMutex.Unlock()
+
+	kept := c.session == "" || session.Resumed
+
+	c.session = session.Token
+	c.PlayerId = session.PlayerId
+
+	return kept
 }
 
 func (c *Client) Send(message Message) {
@@ -244,10 +943,29 @@ func (c *Client) Send(message Message) {
 	c.Outgoing <- message
 }
 
+// Sends the message with a fresh request ID, so the server answers it
+// with an "ack" or an "error" carrying the same ID
+func (c *Client) Request(message Message) string {
+	message.RequestId = uuid.NewString()
+	c.Send(message)
+
+	return message.RequestId
+}
+
+// Closes the connection telling the server we are not coming back
 func (c *Client) Close() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	c.socketMutex.Lock()
+	defer c.socketMutex.Unlock()
+
+	atomic.StoreInt32(&c.running, 0)
+
+	c.socket.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
 	c.socket.Close()
-	c.Running = false
 }
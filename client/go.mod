@@ -3,6 +3,6 @@ module example.com/game/client
 go 1.17
 
 require (
-	github.com/google/uuid v1.3.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/google/uuid v1.3.0
+	github.com/gorilla/websocket v1.5.0
 )
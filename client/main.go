@@ -1,10 +1,32 @@
 package main
 
-import "example.com/game/client/client"
+import (
+	"flag"
+	"log"
+
+	"example.com/game/client/client"
+)
 
 func main() {
+	addr := flag.String("addr", "0.0.0.0:8080", "server address")
+	useTLS := flag.Bool("tls", false, "connect with wss://")
+	caFile := flag.String("ca-file", "", "PEM bundle of extra trusted authorities, implies -tls")
+	insecure := flag.Bool("insecure", false, "skip certificate verification, for development only, implies -tls")
+	flag.Parse()
+
 	c := client.NewClient()
-	c.Connect("0.0.0.0:8080")
+
+	if *useTLS || *caFile != "" || *insecure {
+		config, err := client.NewTLSConfig(*caFile, *insecure)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.TLS = config
+	}
+
+	if err := c.Connect(*addr); err != nil {
+		log.Fatal(err)
+	}
 
 	c.Loop()
 }
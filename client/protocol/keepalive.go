@@ -0,0 +1,31 @@
+package protocol
+
+import "time"
+
+// Keepalive configures the ping/pong heartbeats both ends use to detect a
+// peer that stopped responding
+type Keepalive struct {
+	// How often a ping is sent
+	PingInterval time.Duration
+	// How long to wait for any frame, pongs included, before giving up
+	PongWait time.Duration
+	// How long a single write may take
+	WriteWait time.Duration
+}
+
+func DefaultKeepalive() Keepalive {
+	return Keepalive{
+		PingInterval: 20 * time.Second,
+		PongWait:     30 * time.Second,
+		WriteWait:    10 * time.Second,
+	}
+}
+
+// Returns the next read deadline, PongWait from now
+func (k Keepalive) ReadDeadline() time.Time {
+	return time.Now().Add(k.PongWait)
+}
+
+func (k Keepalive) WriteDeadline() time.Time {
+	return time.Now().Add(k.WriteWait)
+}
@@ -0,0 +1,632 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	MALFORMED          = "malformed"
+	UNKNOWN_TYPE       = "unknown_type"
+	INVALID_PAYLOAD    = "invalid_payload"
+	GAME_NOT_FOUND     = "game_not_found"
+	MATCH_NOT_FOUND    = "match_not_found"
+	SHUTTING_DOWN      = "shutting_down"
+	INVALID_GUESS      = "invalid_guess"
+	NOT_AUTHORIZED     = "not_authorized"
+	NOT_YOUR_TURN      = "not_your_turn"
+	NOT_STARTED        = "not_started"
+	INVALID_SECRET     = "invalid_secret"
+	REMATCH_NOT_FOUND  = "rematch_not_found"
+	NO_DRAW_OFFER      = "no_draw_offer"
+	QUEUE_NOT_FOUND    = "queue_not_found"
+	PARTY_NOT_FOUND    = "party_not_found"
+	PLAYER_NOT_FOUND   = "player_not_found"
+	PARTY_TOO_BIG      = "party_too_big"
+	PLAYER_BUSY        = "player_busy"
+	LOBBY_NOT_FOUND    = "lobby_not_found"
+	LOBBY_FULL         = "lobby_full"
+	NOT_ENOUGH_PLAYERS = "not_enough_players"
+)
+
+type Empty struct{}
+
+func (p *Empty) Validate() error {
+	return nil
+}
+
+type QueueUp struct {
+	// Name of the queue to join, empty for the server's default
+	Queue string `json:"queue"`
+}
+
+func (p *QueueUp) Validate() error {
+	return nil
+}
+
+type Dequeue struct{}
+
+func (p *Dequeue) Validate() error {
+	return nil
+}
+
+type MatchConfirmed struct {
+	MatchId string `json:"matchId"`
+}
+
+func (p *MatchConfirmed) Validate() error {
+	if p.MatchId == "" {
+		return errors.New("matchId is required")
+	}
+	return nil
+}
+
+type MatchDeclined struct {
+	MatchId string `json:"matchId"`
+}
+
+func (p *MatchDeclined) Validate() error {
+	if p.MatchId == "" {
+		return errors.New("matchId is required")
+	}
+	return nil
+}
+
+type Guess struct {
+	GameId string `json:"gameId"`
+	Guess  int    `json:"guess"`
+}
+
+func (p *Guess) Validate() error {
+	if p.GameId == "" {
+		return errors.New("gameId is required")
+	}
+	return nil
+}
+
+// Payload of "forfeit", "offer_draw" and "accept_draw"
+type GameAction struct {
+	GameId string `json:"gameId"`
+}
+
+func (p *GameAction) Validate() error {
+	if p.GameId == "" {
+		return errors.New("gameId is required")
+	}
+	return nil
+}
+
+// Payload of "invite_player", "kick_player" and "promote_player", naming
+// the player by the ID the server gave them in "session"
+type PartyAction struct {
+	PlayerId string `json:"playerId"`
+}
+
+func (p *PartyAction) Validate() error {
+	if p.PlayerId == "" {
+		return errors.New("playerId is required")
+	}
+	return nil
+}
+
+type JoinParty struct {
+	PartyId string `json:"partyId"`
+}
+
+func (p *JoinParty) Validate() error {
+	if p.PartyId == "" {
+		return errors.New("partyId is required")
+	}
+	return nil
+}
+
+// Opens a private lobby. Without rules the lobby plays by the server's.
+type CreateLobby struct {
+	Rules      *GameRules `json:"rules"`
+	MaxPlayers int        `json:"maxPlayers"`
+}
+
+func (p *CreateLobby) Validate() error {
+	if p.MaxPlayers < 0 {
+		return errors.New("maxPlayers can't be negative")
+	}
+	if p.Rules != nil {
+		return p.Rules.Validate()
+	}
+	return nil
+}
+
+type JoinLobby struct {
+	Code string `json:"code"`
+}
+
+func (p *JoinLobby) Validate() error {
+	if p.Code == "" {
+		return errors.New("code is required")
+	}
+	return nil
+}
+
+// Secret a player picks in duels
+type Secret struct {
+	GameId string `json:"gameId"`
+	Secret int    `json:"secret"`
+}
+
+func (p *Secret) Validate() error {
+	if p.GameId == "" {
+		return errors.New("gameId is required")
+	}
+	return nil
+}
+
+type RematchAccepted struct {
+	RematchId string `json:"rematchId"`
+}
+
+func (p *RematchAccepted) Validate() error {
+	if p.RematchId == "" {
+		return errors.New("rematchId is required")
+	}
+	return nil
+}
+
+type RematchDeclined struct {
+	RematchId string `json:"rematchId"`
+}
+
+func (p *RematchDeclined) Validate() error {
+	if p.RematchId == "" {
+		return errors.New("rematchId is required")
+	}
+	return nil
+}
+
+type MatchFound struct {
+	MatchId string `json:"matchId"`
+}
+
+func (p *MatchFound) Validate() error {
+	return nil
+}
+
+type MatchCanceled struct {
+	MatchId string `json:"matchId"`
+}
+
+func (p *MatchCanceled) Validate() error {
+	return nil
+}
+
+type GameStart struct {
+	GameId string    `json:"gameId"`
+	Rules  GameRules `json:"rules"`
+	// Number of the receiving player, out of Players
+	Player  int `json:"player"`
+	Players int `json:"players"`
+}
+
+func (p *GameStart) Validate() error {
+	return nil
+}
+
+type Feedback struct {
+	Message string `json:"message"`
+	// Guesses left, -1 meaning unlimited
+	Remaining int `json:"remaining"`
+}
+
+func (p *Feedback) Validate() error {
+	return nil
+}
+
+type Victory struct {
+	Message string `json:"message"`
+}
+
+func (p *Victory) Validate() error {
+	return nil
+}
+
+type Loss struct {
+	Message string `json:"message"`
+}
+
+func (p *Loss) Validate() error {
+	return nil
+}
+
+type Draw struct {
+	Message string `json:"message"`
+}
+
+func (p *Draw) Validate() error {
+	return nil
+}
+
+// Asks the players of a duel to pick their secret before Deadline
+type ChooseSecret struct {
+	Deadline time.Time `json:"deadline"`
+}
+
+func (p *ChooseSecret) Validate() error {
+	return nil
+}
+
+// Ends the selection of secrets in duels. Drawn tells the server picked
+// the player's secret since they didn't in time. Opponent is the player
+// whose secret they have to guess.
+type SecretsChosen struct {
+	Secret   int  `json:"secret"`
+	Drawn    bool `json:"drawn"`
+	Opponent int  `json:"opponent"`
+}
+
+func (p *SecretsChosen) Validate() error {
+	return nil
+}
+
+// Announces whose turn it is in TURN_BASED games
+type Turn struct {
+	Player   int       `json:"player"`
+	Deadline time.Time `json:"deadline"`
+}
+
+func (p *Turn) Validate() error {
+	return nil
+}
+
+// Shows the other players a guess made in TURN_BASED games
+type OpponentGuess struct {
+	Player  int    `json:"player"`
+	Guess   int    `json:"guess"`
+	Message string `json:"message"`
+}
+
+func (p *OpponentGuess) Validate() error {
+	return nil
+}
+
+// Sent to everyone when a player lets their turn run out. Forfeited
+// players are out of the game.
+type TurnMissed struct {
+	Player    int  `json:"player"`
+	Forfeited bool `json:"forfeited"`
+}
+
+func (p *TurnMissed) Validate() error {
+	return nil
+}
+
+// Tells the players still in the game that another one forfeited, or
+// disconnected when Left is set
+type PlayerForfeited struct {
+	Player int  `json:"player"`
+	Left   bool `json:"left"`
+}
+
+func (p *PlayerForfeited) Validate() error {
+	return nil
+}
+
+// Sent to the players who didn't agree to a draw yet
+type DrawOffered struct {
+	Player int `json:"player"`
+}
+
+func (p *DrawOffered) Validate() error {
+	return nil
+}
+
+// Sent to a player who guessed it while the others keep playing
+type Finished struct {
+	Place   int    `json:"place"`
+	Message string `json:"message"`
+}
+
+func (p *Finished) Validate() error {
+	return nil
+}
+
+type Standing struct {
+	Player  int  `json:"player"`
+	Place   int  `json:"place"`
+	Guesses int  `json:"guesses"`
+	Solved  bool `json:"solved"`
+}
+
+// Sent to every player when the game ends, ordered by place. Players who
+// didn't guess it share the last place. You is the receiving player.
+type Standings struct {
+	GameId    string     `json:"gameId"`
+	You       int        `json:"you"`
+	Standings []Standing `json:"standings"`
+}
+
+func (p *Standings) Validate() error {
+	return nil
+}
+
+// Formats a place, e.g. 1st or 22nd
+func Ordinal(n int) string {
+	suffix := "th"
+
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+type SeriesScore struct {
+	Player int `json:"player"`
+	Wins   int `json:"wins"`
+}
+
+// Sent after every round of a best-of-N series. Winner is the player who
+// won the series once it's Over, 0 if it ended tied.
+type Series struct {
+	SeriesId string        `json:"seriesId"`
+	Round    int           `json:"round"`
+	BestOf   int           `json:"bestOf"`
+	Scores   []SeriesScore `json:"scores"`
+	Over     bool          `json:"over"`
+	Winner   int           `json:"winner"`
+}
+
+func (p *Series) Validate() error {
+	return nil
+}
+
+// Offers the players of a finished game to play again until Deadline
+type RematchOffer struct {
+	RematchId string    `json:"rematchId"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+func (p *RematchOffer) Validate() error {
+	return nil
+}
+
+type RematchCanceled struct {
+	RematchId string `json:"rematchId"`
+}
+
+func (p *RematchCanceled) Validate() error {
+	return nil
+}
+
+type OutOfGuesses struct {
+	Message string `json:"message"`
+}
+
+func (p *OutOfGuesses) Validate() error {
+	return nil
+}
+
+type GameAborted struct {
+	Message string `json:"message"`
+}
+
+func (p *GameAborted) Validate() error {
+	return nil
+}
+
+// Sent to everyone when the server starts draining. Running games may
+// finish until Deadline, when they are aborted and connections closed.
+type ServerShuttingDown struct {
+	Deadline time.Time `json:"deadline"`
+}
+
+func (p *ServerShuttingDown) Validate() error {
+	return nil
+}
+
+// A queue players can join and how many are waiting in it
+type QueueInfo struct {
+	Name    string    `json:"name"`
+	Players int       `json:"players"`
+	Waiting int       `json:"waiting"`
+	Ranked  bool      `json:"ranked"`
+	Rules   GameRules `json:"rules"`
+}
+
+// Reply to "list_queues"
+type Queues struct {
+	Default string      `json:"default"`
+	Queues  []QueueInfo `json:"queues"`
+}
+
+func (p *Queues) Validate() error {
+	return nil
+}
+
+// Skill rating of the player, the lower the deviation the more certain it is.
+// It starts over with every new session.
+type Profile struct {
+	Rating    float64 `json:"rating"`
+	Deviation float64 `json:"deviation"`
+	Games     int     `json:"games"`
+}
+
+func (p *Profile) Validate() error {
+	return nil
+}
+
+// Members of the player's party, sent whenever it changes
+type Party struct {
+	PartyId string   `json:"partyId"`
+	Leader  string   `json:"leader"`
+	Members []string `json:"members"`
+}
+
+func (p *Party) Validate() error {
+	return nil
+}
+
+type PartyInvite struct {
+	PartyId string `json:"partyId"`
+	From    string `json:"from"`
+}
+
+func (p *PartyInvite) Validate() error {
+	return nil
+}
+
+// The player is no longer in the party, either because they left or the
+// leader kicked them
+type LeftParty struct {
+	PartyId string `json:"partyId"`
+	Kicked  bool   `json:"kicked"`
+}
+
+func (p *LeftParty) Validate() error {
+	return nil
+}
+
+// Players of the lobby, sent whenever it changes. Others join it with Code.
+type Lobby struct {
+	Code       string    `json:"code"`
+	Host       string    `json:"host"`
+	Players    []string  `json:"players"`
+	MaxPlayers int       `json:"maxPlayers"`
+	Rules      GameRules `json:"rules"`
+}
+
+func (p *Lobby) Validate() error {
+	return nil
+}
+
+type LeftLobby struct {
+	Code string `json:"code"`
+}
+
+func (p *LeftLobby) Validate() error {
+	return nil
+}
+
+// Sent periodically while waiting for a match. Position counts players,
+// party members included, and EstimatedWait is 0 until the queue has
+// matched anyone recently.
+type QueueStatus struct {
+	Queue         string        `json:"queue"`
+	Position      int           `json:"position"`
+	Size          int           `json:"size"`
+	EstimatedWait time.Duration `json:"estimatedWait"`
+}
+
+func (p *QueueStatus) Validate() error {
+	return nil
+}
+
+// The player was taken out of the queue without asking, e.g. when their
+// party changed
+type Dequeued struct {
+	Message string `json:"message"`
+}
+
+func (p *Dequeued) Validate() error {
+	return nil
+}
+
+// Sent on connect with the token that resumes the session after the
+// connection drops. Resumed tells whether a previous session was resumed.
+// PlayerId is what other players invite the player to parties with.
+type Session struct {
+	Token    string `json:"token"`
+	PlayerId string `json:"playerId"`
+	Resumed  bool   `json:"resumed"`
+}
+
+func (p *Session) Validate() error {
+	return nil
+}
+
+// Error is both the payload of "error" messages and the error returned
+// by Decode
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewError(code string, message string) *Error {
+	return &Error{code, message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Validate() error {
+	return nil
+}
+
+func init() {
+	Register(ToServer, "queue_up", func() Payload { return &QueueUp{} })
+	Register(ToServer, "dequeue", func() Payload { return &Dequeue{} })
+	Register(ToServer, "match_confirmed", func() Payload { return &MatchConfirmed{} })
+	Register(ToServer, "match_declined", func() Payload { return &MatchDeclined{} })
+	Register(ToServer, "guess", func() Payload { return &Guess{} })
+	Register(ToServer, "secret", func() Payload { return &Secret{} })
+	Register(ToServer, "forfeit", func() Payload { return &GameAction{} })
+	Register(ToServer, "offer_draw", func() Payload { return &GameAction{} })
+	Register(ToServer, "accept_draw", func() Payload { return &GameAction{} })
+	Register(ToServer, "rematch_accepted", func() Payload { return &RematchAccepted{} })
+	Register(ToServer, "rematch_declined", func() Payload { return &RematchDeclined{} })
+	Register(ToServer, "profile", func() Payload { return &Empty{} })
+	Register(ToServer, "list_queues", func() Payload { return &Empty{} })
+	Register(ToServer, "create_party", func() Payload { return &Empty{} })
+	Register(ToServer, "invite_player", func() Payload { return &PartyAction{} })
+	Register(ToServer, "join_party", func() Payload { return &JoinParty{} })
+	Register(ToServer, "leave_party", func() Payload { return &Empty{} })
+	Register(ToServer, "kick_player", func() Payload { return &PartyAction{} })
+	Register(ToServer, "promote_player", func() Payload { return &PartyAction{} })
+	Register(ToServer, "create_lobby", func() Payload { return &CreateLobby{} })
+	Register(ToServer, "join_lobby", func() Payload { return &JoinLobby{} })
+	Register(ToServer, "leave_lobby", func() Payload { return &Empty{} })
+	Register(ToServer, "start_lobby", func() Payload { return &Empty{} })
+
+	Register(ToClient, "session", func() Payload { return &Session{} })
+	Register(ToClient, "wait_for_match", func() Payload { return &Empty{} })
+	Register(ToClient, "match_found", func() Payload { return &MatchFound{} })
+	Register(ToClient, "match_canceled", func() Payload { return &MatchCanceled{} })
+	Register(ToClient, "wait_for_players", func() Payload { return &Empty{} })
+	Register(ToClient, "guess", func() Payload { return &GameStart{} })
+	Register(ToClient, "feedback", func() Payload { return &Feedback{} })
+	Register(ToClient, "victory", func() Payload { return &Victory{} })
+	Register(ToClient, "loss", func() Payload { return &Loss{} })
+	Register(ToClient, "draw", func() Payload { return &Draw{} })
+	Register(ToClient, "choose_secret", func() Payload { return &ChooseSecret{} })
+	Register(ToClient, "secrets_chosen", func() Payload { return &SecretsChosen{} })
+	Register(ToClient, "turn", func() Payload { return &Turn{} })
+	Register(ToClient, "opponent_guess", func() Payload { return &OpponentGuess{} })
+	Register(ToClient, "turn_missed", func() Payload { return &TurnMissed{} })
+	Register(ToClient, "player_forfeited", func() Payload { return &PlayerForfeited{} })
+	Register(ToClient, "draw_offered", func() Payload { return &DrawOffered{} })
+	Register(ToClient, "finished", func() Payload { return &Finished{} })
+	Register(ToClient, "standings", func() Payload { return &Standings{} })
+	Register(ToClient, "series_score", func() Payload { return &Series{} })
+	Register(ToClient, "rematch_offer", func() Payload { return &RematchOffer{} })
+	Register(ToClient, "rematch_canceled", func() Payload { return &RematchCanceled{} })
+	Register(ToClient, "out_of_guesses", func() Payload { return &OutOfGuesses{} })
+	Register(ToClient, "profile", func() Payload { return &Profile{} })
+	Register(ToClient, "queues", func() Payload { return &Queues{} })
+	Register(ToClient, "party", func() Payload { return &Party{} })
+	Register(ToClient, "party_invite", func() Payload { return &PartyInvite{} })
+	Register(ToClient, "left_party", func() Payload { return &LeftParty{} })
+	Register(ToClient, "dequeued", func() Payload { return &Dequeued{} })
+	Register(ToClient, "queue_status", func() Payload { return &QueueStatus{} })
+	Register(ToClient, "lobby", func() Payload { return &Lobby{} })
+	Register(ToClient, "left_lobby", func() Payload { return &LeftLobby{} })
+	Register(ToClient, "game_aborted", func() Payload { return &GameAborted{} })
+	Register(ToClient, "server_shutting_down", func() Payload { return &ServerShuttingDown{} })
+	Register(ToClient, "ack", func() Payload { return &Empty{} })
+	Register(ToClient, "error", func() Payload { return &Error{} })
+}
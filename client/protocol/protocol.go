@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Direction tells which side of the connection receives a message. The same
+// type may carry different payloads in each direction, e.g. "guess".
+type Direction int
+
+const (
+	ToServer Direction = iota
+	ToClient
+)
+
+// Payload is implemented by every message body, so it can be checked
+// right after being decoded
+type Payload interface {
+	Validate() error
+}
+
+// Message is the frame exchanged on the wire. RequestId is optional and, when
+// set by the client, is echoed back in the "ack" or "error" reply.
+type Message struct {
+	Type      string
+	Payload   interface{}
+	RequestId string `json:",omitempty"`
+}
+
+type envelope struct {
+	Type      string
+	Payload   json.RawMessage
+	RequestId string
+}
+
+var registry = map[Direction]map[string]func() Payload{
+	ToServer: make(map[string]func() Payload),
+	ToClient: make(map[string]func() Payload),
+}
+
+// Registers a message type along with the constructor of its payload
+func Register(direction Direction, msgType string, create func() Payload) {
+	if _, ok := registry[direction][msgType]; ok {
+		panic(fmt.Sprintf("protocol: message \"%s\" registered twice", msgType))
+	}
+	registry[direction][msgType] = create
+}
+
+// Decodes a frame into a message with a typed payload. Only types registered
+// for the given direction are accepted. The returned error is always an
+// *Error, so it can be sent back as is.
+func Decode(data []byte, direction Direction) (Message, error) {
+	var env envelope
+
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Message{}, NewError(MALFORMED, "Message is not valid JSON")
+	}
+
+	msg := Message{Type: env.Type, RequestId: env.RequestId}
+
+	create, ok := registry[direction][env.Type]
+	if !ok {
+		return msg, NewError(UNKNOWN_TYPE, fmt.Sprintf("Unknown message type \"%s\"", env.Type))
+	}
+
+	payload := create()
+
+	if len(env.Payload) > 0 && !bytes.Equal(env.Payload, []byte("null")) {
+		decoder := json.NewDecoder(bytes.NewReader(env.Payload))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(payload); err != nil {
+			return msg, NewError(INVALID_PAYLOAD, fmt.Sprintf("Invalid payload for \"%s\": %v", env.Type, err))
+		}
+	}
+
+	if err := payload.Validate(); err != nil {
+		return msg, NewError(INVALID_PAYLOAD, err.Error())
+	}
+
+	msg.Payload = payload
+	return msg, nil
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Game modes players pick when queueing up
+const (
+	// Everyone guesses at will, the fastest wins
+	FREE_FOR_ALL = "free"
+	// Players guess in rotation, each turn timed
+	TURN_BASED = "turns"
+)
+
+func ValidMode(mode string) bool {
+	return mode == "" || mode == FREE_FOR_ALL || mode == TURN_BASED
+}
+
+// Game variants, i.e. what the secret is and how guesses are scored
+const (
+	// Guess a number in [Min, Max], told whether it's higher or lower
+	HIGHER_OR_LOWER = "higher_or_lower"
+	// Guess a code of distinct Digits, told how many are in the right
+	// place (bulls) and how many are elsewhere (cows)
+	BULLS_AND_COWS = "bulls_and_cows"
+)
+
+func ValidVariant(variant string) bool {
+	return variant == "" || variant == HIGHER_OR_LOWER || variant == BULLS_AND_COWS
+}
+
+// GameRules is sent along with the start of each game
+type GameRules struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+	// Guesses allowed per player, 0 for unlimited
+	MaxGuesses int `json:"maxGuesses"`
+	// How long the game may last before it's a draw, 0 for no limit
+	TimeLimit time.Duration `json:"timeLimit"`
+	// Whether all players guess the same number or each has their own
+	SharedAnswer bool `json:"sharedAnswer"`
+	// Whether the game goes on after the first correct guess until every
+	// player guessed it or ran out of guesses
+	KeepPlaying bool `json:"keepPlaying"`
+
+	// FREE_FOR_ALL or TURN_BASED, empty meaning FREE_FOR_ALL
+	Mode string `json:"mode"`
+	// How long each turn lasts in TURN_BASED games
+	TurnTime time.Duration `json:"turnTime"`
+	// Consecutive turns a player may miss before forfeiting, 0 to only
+	// skip them
+	MaxMissedTurns int `json:"maxMissedTurns"`
+
+	// HIGHER_OR_LOWER or BULLS_AND_COWS, empty meaning HIGHER_OR_LOWER
+	Variant string `json:"variant"`
+	// Length of the code in BULLS_AND_COWS games
+	Digits int `json:"digits"`
+
+	// Whether each player picks the secret the next player has to guess,
+	// within SecretTime, instead of the server drawing them
+	Duel       bool          `json:"duel"`
+	SecretTime time.Duration `json:"secretTime"`
+}
+
+func (r GameRules) BullsAndCows() bool {
+	return r.Variant == BULLS_AND_COWS
+}
+
+// Formats a guess or answer, keeping the leading zeros of codes
+func (r GameRules) Format(number int) string {
+	if r.BullsAndCows() {
+		return fmt.Sprintf("%0*d", r.Digits, number)
+	}
+	return fmt.Sprint(number)
+}
+
+func (r GameRules) TurnBased() bool {
+	return r.Mode == TURN_BASED
+}
+
+func (r GameRules) Validate() error {
+	switch {
+	case r.Min >= r.Max:
+		return errors.New("min must be smaller than max")
+	case uint(r.Max)-uint(r.Min) >= math.MaxInt:
+		// Draw couldn't count the numbers in between
+		return errors.New("range between min and max is too large")
+	case r.MaxGuesses < 0:
+		return errors.New("max guesses can't be negative")
+	case r.TimeLimit < 0:
+		return errors.New("time limit can't be negative")
+	case !ValidMode(r.Mode):
+		return errors.New("unknown mode")
+	case r.TurnBased() && r.TurnTime <= 0:
+		return errors.New("turn time must be positive")
+	case r.MaxMissedTurns < 0:
+		return errors.New("max missed turns can't be negative")
+	case !ValidVariant(r.Variant):
+		return errors.New("unknown variant")
+	case r.BullsAndCows() && (r.Digits < 1 || r.Digits > 10):
+		return errors.New("digits must be between 1 and 10")
+	case r.Duel && r.SecretTime <= 0:
+		return errors.New("secret time must be positive")
+	}
+	return nil
+}
+
+// Draws a number in [Min, Max]
+func (r GameRules) Draw() int {
+	return r.Min + rand.Intn(r.Max-r.Min+1)
+}
@@ -1,18 +1,62 @@
 package main
 
 import (
-	"time"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"example.com/game/server/server"
 )
 
 func main() {
-	server := server.NewServer([]server.EventHandler{
-		server.NewGameManager(),
-		server.NewQueueManager(),
-		server.NewMatchMaker(10 * time.Second),
+	config, err := server.LoadConfig(os.Args[1:])
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	server.SetLogLevel(config.LogLevel)
+
+	ratings := server.NewRatingManager()
+	parties := server.NewPartyManager()
+	games := server.NewGameManager(config)
+	matchMaker := server.NewMatchMaker(config)
+	matchMaker.UseParties(parties)
+
+	queues := server.NewQueueManager(config)
+	queues.UseRatings(ratings)
+	queues.UseParties(parties)
+	queues.UseBusy(games, matchMaker)
+
+	lobbies := server.NewLobbyManager(config)
+	lobbies.UseBusy(games, matchMaker)
+
+	s := server.NewServer(config, []server.EventHandler{
+		games,
+		queues,
+		matchMaker,
+		ratings,
+		parties,
+		lobbies,
 	})
 
-	defer server.Close()
-	server.Listen("0.0.0.0:8080")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		server.Infof("Shutting down, waiting for running games to finish")
+		s.Shutdown(config.ShutdownTimeout)
+	}()
+
+	if err := s.Listen(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"fmt"
+
+	"example.com/game/client/protocol"
+)
+
+// Checks the sender of the event is one of members before it acts on the
+// given resource, e.g. "game" or "match". Unauthorized events are rejected
+// and recorded in the audit log, the caller must drop them.
+func Authorize(event Event, members *Sockets, resource string, id string) bool {
+	if event.Socket != nil && members.Has(event.Socket) {
+		return true
+	}
+
+	auditf("socket %d denied %s on %s %s: not a player", socketId(event.Socket), event.Type, resource, id)
+	event.Reject(protocol.NOT_AUTHORIZED, fmt.Sprintf("You are not a player of %s %s", resource, id))
+
+	return false
+}
+
+func auditf(format string, args ...interface{}) {
+	Warnf("audit: "+format, args...)
+}
+
+func socketId(socket *Socket) uint64 {
+	if socket == nil {
+		return 0
+	}
+	return socket.id
+}
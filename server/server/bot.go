@@ -0,0 +1,231 @@
+package server
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"example.com/game/client/protocol"
+)
+
+// How smart a bot is
+type Difficulty int
+
+const (
+	// Guesses anywhere in the range
+	RANDOM Difficulty = iota
+	// Halves what's left of the range every guess
+	BINARY_SEARCH
+	// Mostly halves the range but sometimes picks a worse number, and
+	// takes more or less time to think like a person would
+	HUMAN
+)
+
+// Chance of a HUMAN bot guessing a random number instead of halving
+const BOT_MISTAKE_RATE = 0.2
+
+// How long a bot waits for a rematch or another round after a game
+// before leaving
+const BOT_IDLE_TIMEOUT = time.Minute
+
+// Bot is a server-side player. It takes a seat through a Socket like any
+// connected player and plays by sending the same messages a client would.
+//
+// Bots narrow down numbers from feedback in higher or lower games, and
+// guess random codes in other variants.
+type Bot struct {
+	Socket     *Socket
+	difficulty Difficulty
+	thinkTime  time.Duration
+	dispatch   func(event Event)
+
+	gameId   string
+	player   int
+	rules    GameRules
+	low      int
+	high     int
+	last     int
+	playing  bool
+	guessing bool
+}
+
+func NewBot(difficulty Difficulty, thinkTime time.Duration, dispatch func(event Event)) *Bot {
+	return &Bot{
+		Socket:     NewBotSocket(),
+		difficulty: difficulty,
+		thinkTime:  thinkTime,
+		dispatch:   dispatch,
+	}
+}
+
+// Plays until the bot is left without a match or game
+func (b *Bot) Run() {
+	defer b.dispatch(Event{
+		Type:   "disconnected",
+		Socket: b.Socket,
+	})
+
+	var next <-chan time.Time
+
+	for {
+		var idle <-chan time.Time
+		if !b.playing {
+			idle = time.After(BOT_IDLE_TIMEOUT)
+		}
+
+		select {
+		case msg := <-b.Socket.Inbox():
+			if !b.handle(msg) {
+				return
+			}
+		case <-next:
+			next = nil
+			if b.guessing {
+				b.guess()
+			}
+		case <-idle:
+			return
+		}
+
+		if b.guessing && next == nil {
+			next = time.After(b.think())
+		}
+	}
+}
+
+// Reacts to a message, returning false once there is nothing left to play
+func (b *Bot) handle(msg Message) bool {
+	switch msg.Type {
+	case "match_found":
+		b.send("match_confirmed", &protocol.MatchConfirmed{
+			MatchId: msg.Payload.(protocol.MatchFound).MatchId,
+		})
+
+	case "match_canceled", "rematch_canceled", "game_aborted":
+		return false
+
+	case "guess":
+		start := msg.Payload.(protocol.GameStart)
+
+		b.gameId = start.GameId
+		b.player = start.Player
+		b.rules = start.Rules
+		b.low = start.Rules.Min
+		b.high = start.Rules.Max
+		b.playing = true
+		b.guessing = !start.Rules.Duel && !start.Rules.TurnBased()
+
+	case "choose_secret":
+		b.send("secret", &protocol.Secret{
+			GameId: b.gameId,
+			Secret: VariantFor(b.rules).Draw(b.rules),
+		})
+
+	case "secrets_chosen":
+		b.guessing = !b.rules.TurnBased()
+
+	case "turn":
+		b.guessing = msg.Payload.(protocol.Turn).Player == b.player
+
+	case "feedback":
+		switch msg.Payload.(protocol.Feedback).Message {
+		case GREATER_HINT:
+			b.low = b.last + 1
+		case SMALLER_HINT:
+			b.high = b.last - 1
+		}
+		b.guessing = !b.rules.TurnBased()
+
+	case "finished", "out_of_guesses", "victory", "loss", "draw":
+		b.guessing = false
+
+	case "standings":
+		b.playing = false
+		b.guessing = false
+
+	case "rematch_offer":
+		b.send("rematch_accepted", &protocol.RematchAccepted{
+			RematchId: msg.Payload.(protocol.RematchOffer).RematchId,
+		})
+	}
+
+	return true
+}
+
+func (b *Bot) guess() {
+	b.guessing = false
+	b.last = b.pick()
+
+	b.send("guess", &protocol.Guess{
+		GameId: b.gameId,
+		Guess:  b.last,
+	})
+}
+
+// Picks the next guess within what the feedback left
+func (b *Bot) pick() int {
+	if b.rules.BullsAndCows() {
+		return VariantFor(b.rules).Draw(b.rules)
+	}
+
+	if b.high < b.low {
+		b.low, b.high = b.rules.Min, b.rules.Max
+	}
+
+	random := b.low + rand.Intn(b.high-b.low+1)
+
+	switch b.difficulty {
+	case RANDOM:
+		return b.rules.Min + rand.Intn(b.rules.Max-b.rules.Min+1)
+	case HUMAN:
+		if rand.Float64() < BOT_MISTAKE_RATE {
+			return random
+		}
+	}
+	return (b.low + b.high) / 2
+}
+
+// How long until the next guess, anywhere from half to one and a half the
+// think time for HUMAN bots
+func (b *Bot) think() time.Duration {
+	if b.difficulty != HUMAN || b.thinkTime <= 0 {
+		return b.thinkTime
+	}
+	return b.thinkTime/2 + time.Duration(rand.Int63n(int64(b.thinkTime)))
+}
+
+func (b *Bot) send(msgType string, payload interface{}) {
+	b.dispatch(Event{
+		Type:    msgType,
+		Payload: payload,
+		Socket:  b.Socket,
+	})
+}
+
+func (d *Difficulty) String() string {
+	if d == nil {
+		return "random"
+	}
+
+	switch *d {
+	case BINARY_SEARCH:
+		return "binary"
+	case HUMAN:
+		return "human"
+	}
+	return "random"
+}
+
+func (d *Difficulty) Set(value string) error {
+	switch value {
+	case "random":
+		*d = RANDOM
+	case "binary":
+		*d = BINARY_SEARCH
+	case "human":
+		*d = HUMAN
+	default:
+		return errors.New("expected random, binary or human")
+	}
+	return nil
+}
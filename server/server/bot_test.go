@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBotPicksWithinFeedback(t *testing.T) {
+	for _, difficulty := range []Difficulty{BINARY_SEARCH, HUMAN} {
+		bot := NewBot(difficulty, 0, func(event Event) {})
+		bot.rules = GameRules{Min: 0, Max: 99}
+		bot.low = 40
+		bot.high = 60
+
+		for i := 0; i < 100; i++ {
+			if guess := bot.pick(); guess < 40 || guess > 60 {
+				t.Fatalf("Expected guess between 40 and 60, got %d", guess)
+			}
+		}
+	}
+}
+
+func TestBotBackfillsMatch(t *testing.T) {
+	config := testConfig()
+	config.BotWait = 50 * time.Millisecond
+	config.BotDifficulty = BINARY_SEARCH
+	config.BotThinkTime = time.Millisecond
+
+	queueManager := NewQueueManager(config)
+	server := NewServer(config, []EventHandler{
+		NewGameManager(config),
+		queueManager,
+		NewMatchMaker(config),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := NewTestClient()
+	c.QueueUp() // wait_for_match
+
+	c.AcceptMatch(c.WaitForMatch()) // wait_for_players
+
+	if res := c.GetIncoming(); res.Type != "guess" {
+		t.Fatalf("Expected game with a bot to start, got \"%s\"", res.Type)
+	}
+
+	if queueManager.Count() != 0 {
+		t.Errorf("Expected queue to be empty, got %d", queueManager.Count())
+	}
+
+	// the bot finds any number in 0..99 in at most 7 guesses
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case res := <-c.Client.Incoming:
+			if res.Type == "loss" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expected bot to win")
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/game/client/protocol"
+)
+
+// Prefix of the environment variables overriding the config file, e.g.
+// GAME_PLAYERS_PER_MATCH for the -players-per-match flag
+const ENV_PREFIX = "GAME_"
+
+// Names of the queues players can join
+const (
+	CLASSIC_QUEUE    = "classic"
+	TURN_BASED_QUEUE = "turn-based"
+	FFA_QUEUE        = "ffa-4"
+	RANKED_QUEUE     = "ranked"
+)
+
+type Config struct {
+	Addr     string
+	LogLevel LogLevel
+
+	// TLS is enabled when both files are set or, in development, with a
+	// self-signed certificate
+	CertFile   string
+	KeyFile    string
+	SelfSigned bool
+	// where the self-signed certificate is written for clients to trust
+	SelfSignedFile string
+
+	PlayersPerMatch     int
+	ConfirmationTimeout time.Duration
+	MinNumber           int
+	MaxNumber           int
+	MaxGuesses          int
+	TimeLimit           time.Duration
+	SharedAnswer        bool
+	KeepPlaying         bool
+	Mode                string
+	TurnTime            time.Duration
+	MaxMissedTurns      int
+	Variant             string
+	Digits              int
+	Duel                bool
+	SecretTime          time.Duration
+	BestOf              int
+	RematchTimeout      time.Duration
+	BotWait             time.Duration
+	BotDifficulty       Difficulty
+	BotThinkTime        time.Duration
+	Matchmaking         string
+	MatchWindow         float64
+	MatchWindowGrowth   float64
+	MaxMatchWindow      float64
+	DefaultQueue        string
+	QueueStatusInterval time.Duration
+	// replaces the default queues when set
+	CustomQueues QueueConfigs
+
+	GracePeriod     time.Duration
+	ShutdownTimeout time.Duration
+	Keepalive       protocol.Keepalive
+	SendQueue       int
+	Overflow        OverflowPolicy
+	Workers         int
+	QueueSize       int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr:     "0.0.0.0:8080",
+		LogLevel: INFO,
+
+		SelfSignedFile: filepath.Join(os.TempDir(), "game-server-cert.pem"),
+
+		PlayersPerMatch:     2,
+		ConfirmationTimeout: 10 * time.Second,
+		MinNumber:           0,
+		MaxNumber:           99,
+		MaxGuesses:          0,
+		TimeLimit:           0,
+		SharedAnswer:        true,
+		KeepPlaying:         false,
+		Mode:                protocol.FREE_FOR_ALL,
+		TurnTime:            30 * time.Second,
+		MaxMissedTurns:      2,
+		Variant:             protocol.HIGHER_OR_LOWER,
+		Digits:              4,
+		Duel:                false,
+		SecretTime:          30 * time.Second,
+		BestOf:              1,
+		RematchTimeout:      15 * time.Second,
+		BotWait:             0,
+		BotDifficulty:       HUMAN,
+		BotThinkTime:        2 * time.Second,
+		Matchmaking:         FIFO_STRATEGY,
+		MatchWindow:         100,
+		MatchWindowGrowth:   10,
+		MaxMatchWindow:      500,
+		DefaultQueue:        CLASSIC_QUEUE,
+		QueueStatusInterval: 5 * time.Second,
+
+		GracePeriod:     30 * time.Second,
+		ShutdownTimeout: 2 * time.Minute,
+		Keepalive:       protocol.DefaultKeepalive(),
+		SendQueue:       DEFAULT_SEND_QUEUE,
+		Overflow:        DROP_OLDEST,
+		Workers:         DEFAULT_WORKERS,
+		QueueSize:       DEFAULT_QUEUE_SIZE,
+	}
+}
+
+func (c *Config) flags() *flag.FlagSet {
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+
+	flags.String("config", "", "path to a JSON config file")
+	flags.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	flags.Var(&c.LogLevel, "log-level", "debug, info, warn or error")
+	flags.StringVar(&c.CertFile, "cert-file", c.CertFile, "TLS certificate")
+	flags.StringVar(&c.KeyFile, "key-file", c.KeyFile, "TLS private key")
+	flags.BoolVar(&c.SelfSigned, "self-signed", c.SelfSigned, "serve TLS with a generated certificate, for development")
+	flags.StringVar(&c.SelfSignedFile, "self-signed-file", c.SelfSignedFile, "where the generated certificate is written, for clients' -ca-file")
+
+	flags.IntVar(&c.PlayersPerMatch, "players-per-match", c.PlayersPerMatch, "players in each match")
+	flags.DurationVar(&c.ConfirmationTimeout, "confirmation-timeout", c.ConfirmationTimeout, "how long players have to accept a match")
+	flags.IntVar(&c.MinNumber, "min-number", c.MinNumber, "smallest number that can be drawn")
+	flags.IntVar(&c.MaxNumber, "max-number", c.MaxNumber, "largest number that can be drawn")
+	flags.IntVar(&c.MaxGuesses, "max-guesses", c.MaxGuesses, "guesses allowed per player, 0 for unlimited")
+	flags.DurationVar(&c.TimeLimit, "time-limit", c.TimeLimit, "how long a game may last before it's a draw, 0 for no limit")
+	flags.BoolVar(&c.SharedAnswer, "shared-answer", c.SharedAnswer, "whether all players guess the same number")
+	flags.BoolVar(&c.KeepPlaying, "keep-playing", c.KeepPlaying, "whether players keep guessing after the first correct guess to be ranked")
+	flags.StringVar(&c.Mode, "mode", c.Mode, "free or turns, for games of the classic queue")
+	flags.DurationVar(&c.TurnTime, "turn-time", c.TurnTime, "how long each turn lasts in turn-based games")
+	flags.IntVar(&c.MaxMissedTurns, "max-missed-turns", c.MaxMissedTurns, "turns in a row a player may miss before forfeiting, 0 to only skip them")
+	flags.StringVar(&c.Variant, "variant", c.Variant, "higher_or_lower or bulls_and_cows")
+	flags.IntVar(&c.Digits, "digits", c.Digits, "length of the code in bulls_and_cows games")
+	flags.BoolVar(&c.Duel, "duel", c.Duel, "whether players pick the secrets their opponents guess")
+	flags.DurationVar(&c.SecretTime, "secret-time", c.SecretTime, "how long players have to pick their secret in duels")
+	flags.IntVar(&c.BestOf, "best-of", c.BestOf, "rounds in each series, the player who wins most of them wins it")
+	flags.DurationVar(&c.RematchTimeout, "rematch-timeout", c.RematchTimeout, "how long players have to accept a rematch")
+	flags.DurationVar(&c.BotWait, "bot-wait", c.BotWait, "how long a player waits before bots fill the match, 0 to never use bots")
+	flags.Var(&c.BotDifficulty, "bot-difficulty", "random, binary or human")
+	flags.DurationVar(&c.BotThinkTime, "bot-think-time", c.BotThinkTime, "how long bots take to guess")
+	flags.StringVar(&c.Matchmaking, "matchmaking", c.Matchmaking, "fifo to match players of the classic and turn-based queues in queue order or rating to match players of similar skill")
+	flags.Float64Var(&c.MatchWindow, "match-window", c.MatchWindow, "rating difference allowed between players who just queued up")
+	flags.Float64Var(&c.MatchWindowGrowth, "match-window-growth", c.MatchWindowGrowth, "how much the rating difference allowed grows every second in queue")
+	flags.Float64Var(&c.MaxMatchWindow, "max-match-window", c.MaxMatchWindow, "largest rating difference allowed")
+	flags.Var(&c.CustomQueues, "queues", "JSON list of queues replacing classic, turn-based, ffa-4 and ranked, each with a name, players, matchmaking and rules keyed by setting")
+	flags.StringVar(&c.DefaultQueue, "default-queue", c.DefaultQueue, "queue of players who don't name one")
+	flags.DurationVar(&c.QueueStatusInterval, "queue-status-interval", c.QueueStatusInterval, "how often waiting players are told their position and estimated wait, 0 to never")
+
+	flags.DurationVar(&c.GracePeriod, "grace-period", c.GracePeriod, "how long a dropped player may resume the session")
+	flags.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long running games may take to finish on shutdown")
+	flags.DurationVar(&c.Keepalive.PingInterval, "ping-interval", c.Keepalive.PingInterval, "how often clients are pinged")
+	flags.DurationVar(&c.Keepalive.PongWait, "pong-wait", c.Keepalive.PongWait, "how long a silent client is kept")
+	flags.DurationVar(&c.Keepalive.WriteWait, "write-wait", c.Keepalive.WriteWait, "how long a single write may take")
+	flags.IntVar(&c.SendQueue, "send-queue", c.SendQueue, "outbound messages queued per client")
+	flags.Var(&c.Overflow, "overflow", "drop-oldest or disconnect, when a client's queue is full")
+	flags.IntVar(&c.Workers, "workers", c.Workers, "goroutines processing events")
+	flags.IntVar(&c.QueueSize, "queue-size", c.QueueSize, "events queued per worker")
+
+	return flags
+}
+
+// Builds the config from, in increasing order of precedence, the defaults,
+// the file given by -config, GAME_* environment variables and the flags in
+// args. The file is a JSON object keyed by flag name, e.g.
+//
+//	{"addr": "0.0.0.0:443", "confirmation-timeout": "15s", "max-number": 1000}
+//
+// Queues are a list, each overriding the settings of its games:
+//
+//	{"default-queue": "blitz", "queues": [
+//		{"name": "blitz", "players": 3, "rules": {"time-limit": "1m"}},
+//		{"name": "duel", "players": 2, "matchmaking": "rating", "rules": {"duel": true}}
+//	]}
+func LoadConfig(args []string) (Config, error) {
+	config := DefaultConfig()
+	flags := config.flags()
+
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	set := func(name string, value string, source string) error {
+		if explicit[name] || name == "config" {
+			return nil
+		}
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting \"%s\"", source, name)
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid value \"%s\" for \"%s\": %v", source, value, name, err)
+		}
+		return nil
+	}
+
+	if path := flags.Lookup("config").Value.String(); path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return config, err
+		}
+
+		for name, value := range values {
+			if err := set(name, value, path); err != nil {
+				return config, err
+			}
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		env := ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+
+		if value, ok := os.LookupEnv(env); ok && err == nil {
+			err = set(f.Name, value, env)
+		}
+	})
+
+	if err != nil {
+		return config, err
+	}
+
+	return config, config.Validate()
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	values := make(map[string]string)
+	for name, value := range raw {
+		switch value.(type) {
+		case []interface{}, map[string]interface{}:
+			// e.g. queues, given to the flag as JSON
+			encoded, _ := json.Marshal(value)
+			values[name] = string(encoded)
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+
+	return values, nil
+}
+
+func (c Config) Validate() error {
+	queues, err := c.queues()
+
+	switch {
+	case err != nil:
+		return err
+	case c.Addr == "":
+		return errors.New("addr is required")
+	case (c.CertFile == "") != (c.KeyFile == ""):
+		return errors.New("cert-file and key-file must be given together")
+	case c.SelfSigned && c.CertFile != "":
+		return errors.New("self-signed can't be used with cert-file")
+	case c.PlayersPerMatch < 2:
+		return errors.New("players-per-match must be at least 2")
+	case c.ConfirmationTimeout <= 0:
+		return errors.New("confirmation-timeout must be positive")
+	case c.MinNumber >= c.MaxNumber:
+		return errors.New("min-number must be smaller than max-number")
+	case c.MaxGuesses < 0:
+		return errors.New("max-guesses can't be negative")
+	case c.TimeLimit < 0:
+		return errors.New("time-limit can't be negative")
+	case c.Mode != protocol.FREE_FOR_ALL && c.Mode != protocol.TURN_BASED:
+		return errors.New("mode must be free or turns")
+	case c.TurnTime <= 0:
+		return errors.New("turn-time must be positive")
+	case c.MaxMissedTurns < 0:
+		return errors.New("max-missed-turns can't be negative")
+	case c.Variant != protocol.HIGHER_OR_LOWER && c.Variant != protocol.BULLS_AND_COWS:
+		return errors.New("variant must be higher_or_lower or bulls_and_cows")
+	case c.Digits < 1 || c.Digits > 10:
+		return errors.New("digits must be between 1 and 10")
+	case c.SecretTime <= 0:
+		return errors.New("secret-time must be positive")
+	case c.BestOf < 1:
+		return errors.New("best-of must be at least 1")
+	case c.RematchTimeout <= 0:
+		return errors.New("rematch-timeout must be positive")
+	case c.BotWait < 0:
+		return errors.New("bot-wait can't be negative")
+	case c.BotThinkTime < 0:
+		return errors.New("bot-think-time can't be negative")
+	case c.Matchmaking != FIFO_STRATEGY && c.Matchmaking != RATING_STRATEGY:
+		return errors.New("matchmaking must be fifo or rating")
+	case c.MatchWindow < 0 || c.MatchWindowGrowth < 0:
+		return errors.New("match-window and match-window-growth can't be negative")
+	case c.MaxMatchWindow < c.MatchWindow:
+		return errors.New("max-match-window can't be smaller than match-window")
+	case !c.hasQueue(c.DefaultQueue):
+		return fmt.Errorf("default-queue must be one of %s", strings.Join(c.queueNames(), ", "))
+	case c.QueueStatusInterval < 0:
+		return errors.New("queue-status-interval can't be negative")
+	case c.GracePeriod < 0:
+		return errors.New("grace-period can't be negative")
+	case c.ShutdownTimeout < 0:
+		return errors.New("shutdown-timeout can't be negative")
+	case c.Keepalive.PingInterval <= 0 || c.Keepalive.WriteWait <= 0:
+		return errors.New("ping-interval and write-wait must be positive")
+	case c.Keepalive.PongWait <= c.Keepalive.PingInterval:
+		return errors.New("pong-wait must be longer than ping-interval")
+	case c.SendQueue < 1:
+		return errors.New("send-queue must be at least 1")
+	case c.Workers < 1:
+		return errors.New("workers must be at least 1")
+	case c.QueueSize < 1:
+		return errors.New("queue-size must be at least 1")
+	}
+
+	names := make(map[string]bool)
+	for _, queue := range queues {
+		switch {
+		case queue.Name == "":
+			return errors.New("queues must have a name")
+		case names[queue.Name]:
+			return fmt.Errorf("queue %s is defined twice", queue.Name)
+		case queue.Players < 2:
+			return fmt.Errorf("queue %s must have at least 2 players", queue.Name)
+		case queue.Matchmaking != FIFO_STRATEGY && queue.Matchmaking != RATING_STRATEGY:
+			return fmt.Errorf("matchmaking of queue %s must be fifo or rating", queue.Name)
+		}
+		if err := queue.Rules.Validate(); err != nil {
+			return fmt.Errorf("rules of queue %s: %v", queue.Name, err)
+		}
+		names[queue.Name] = true
+	}
+
+	return nil
+}
+
+// Rules of the games started from the queue
+func (c Config) GameRules() GameRules {
+	return GameRules{
+		Min:          c.MinNumber,
+		Max:          c.MaxNumber,
+		MaxGuesses:   c.MaxGuesses,
+		TimeLimit:    c.TimeLimit,
+		SharedAnswer: c.SharedAnswer,
+		KeepPlaying:  c.KeepPlaying,
+
+		Mode:           c.Mode,
+		TurnTime:       c.TurnTime,
+		MaxMissedTurns: c.MaxMissedTurns,
+
+		Variant: c.Variant,
+		Digits:  c.Digits,
+
+		Duel:       c.Duel,
+		SecretTime: c.SecretTime,
+	}
+}
+
+// A queue players can join by name, bound to its own player count, rules
+// and matchmaking strategy
+type QueueSettings struct {
+	Name        string
+	Players     int
+	Rules       GameRules
+	Matchmaking string
+}
+
+// A queue as given by -queues. Players and matchmaking default to
+// players-per-match and matchmaking.
+type QueueConfig struct {
+	Name        string `json:"name"`
+	Players     int    `json:"players"`
+	Matchmaking string `json:"matchmaking"`
+	// settings making up the rules keyed by flag name, e.g. "mode" or
+	// "max-number", overriding those of the config
+	Rules map[string]interface{} `json:"rules"`
+}
+
+type QueueConfigs []QueueConfig
+
+// Settings a queue may override in its rules
+var QUEUE_RULE_SETTINGS = map[string]bool{
+	"min-number":       true,
+	"max-number":       true,
+	"max-guesses":      true,
+	"time-limit":       true,
+	"shared-answer":    true,
+	"keep-playing":     true,
+	"mode":             true,
+	"turn-time":        true,
+	"max-missed-turns": true,
+	"variant":          true,
+	"digits":           true,
+	"duel":             true,
+	"secret-time":      true,
+}
+
+func (q *QueueConfigs) String() string {
+	if q == nil || len(*q) == 0 {
+		return ""
+	}
+
+	encoded, _ := json.Marshal(*q)
+	return string(encoded)
+}
+
+func (q *QueueConfigs) Set(value string) error {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+
+	queues := QueueConfigs{}
+	if err := decoder.Decode(&queues); err != nil {
+		return err
+	}
+	if len(queues) == 0 {
+		return errors.New("expected at least one queue")
+	}
+
+	*q = queues
+	return nil
+}
+
+// The queues offered, those of -queues if given
+func (c Config) Queues() []QueueSettings {
+	// the config was validated, so the rules can be made up
+	queues, _ := c.queues()
+	return queues
+}
+
+func (c Config) queues() ([]QueueSettings, error) {
+	if len(c.CustomQueues) == 0 {
+		return c.defaultQueues(), nil
+	}
+
+	queues := make([]QueueSettings, 0, len(c.CustomQueues))
+
+	for _, queue := range c.CustomQueues {
+		rules, err := c.queueRules(queue)
+		if err != nil {
+			return nil, err
+		}
+
+		settings := QueueSettings{queue.Name, queue.Players, rules, queue.Matchmaking}
+		if settings.Players == 0 {
+			settings.Players = c.PlayersPerMatch
+		}
+		if settings.Matchmaking == "" {
+			settings.Matchmaking = c.Matchmaking
+		}
+
+		queues = append(queues, settings)
+	}
+
+	return queues, nil
+}
+
+// Applies the rules of the queue over those of the config
+func (c Config) queueRules(queue QueueConfig) (GameRules, error) {
+	config := c
+	flags := config.flags()
+
+	for name, value := range queue.Rules {
+		if !QUEUE_RULE_SETTINGS[name] {
+			return GameRules{}, fmt.Errorf("queue %s: unknown rule \"%s\"", queue.Name, name)
+		}
+		if err := flags.Set(name, fmt.Sprint(value)); err != nil {
+			return GameRules{}, fmt.Errorf("queue %s: invalid value \"%v\" for \"%s\": %v", queue.Name, value, name, err)
+		}
+	}
+
+	return config.GameRules(), nil
+}
+
+// Classic games follow the config, the others tweak it: turn-based games,
+// free-for-alls of four ranking everyone and ranked duels matching players
+// of similar skill.
+func (c Config) defaultQueues() []QueueSettings {
+	classic := c.GameRules()
+
+	turns := c.GameRules()
+	turns.Mode = protocol.TURN_BASED
+
+	ffa := c.GameRules()
+	ffa.Mode = protocol.FREE_FOR_ALL
+	ffa.KeepPlaying = true
+
+	return []QueueSettings{
+		{CLASSIC_QUEUE, c.PlayersPerMatch, classic, c.Matchmaking},
+		{TURN_BASED_QUEUE, c.PlayersPerMatch, turns, c.Matchmaking},
+		{FFA_QUEUE, 4, ffa, FIFO_STRATEGY},
+		{RANKED_QUEUE, 2, classic, RATING_STRATEGY},
+	}
+}
+
+func (c Config) queueNames() []string {
+	names := make([]string, 0)
+	for _, queue := range c.Queues() {
+		names = append(names, queue.Name)
+	}
+	return names
+}
+
+func (c Config) hasQueue(name string) bool {
+	for _, queue := range c.Queues() {
+		if queue.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *OverflowPolicy) String() string {
+	if p != nil && *p == DISCONNECT_SLOW {
+		return "disconnect"
+	}
+	return "drop-oldest"
+}
+
+func (p *OverflowPolicy) Set(value string) error {
+	switch value {
+	case "drop-oldest":
+		*p = DROP_OLDEST
+	case "disconnect":
+		*p = DISCONNECT_SLOW
+	default:
+		return errors.New("expected drop-oldest or disconnect")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{
+		"addr": "127.0.0.1:9000",
+		"players-per-match": 4,
+		"confirmation-timeout": "15s",
+		"max-number": 1000
+	}`), 0644)
+
+	t.Setenv("GAME_PLAYERS_PER_MATCH", "3")
+	t.Setenv("GAME_MAX_NUMBER", "500")
+
+	config, err := LoadConfig([]string{"-config", path, "-max-number", "50"})
+	if err != nil {
+		t.Fatalf("Expected config, got error: %v", err)
+	}
+
+	if config.Addr != "127.0.0.1:9000" {
+		t.Errorf("Expected addr from file, got \"%s\"", config.Addr)
+	}
+	if config.ConfirmationTimeout != 15*time.Second {
+		t.Errorf("Expected timeout from file, got %v", config.ConfirmationTimeout)
+	}
+	if config.PlayersPerMatch != 3 {
+		t.Errorf("Expected environment to override file, got %d", config.PlayersPerMatch)
+	}
+	if config.MaxNumber != 50 {
+		t.Errorf("Expected flag to override environment, got %d", config.MaxNumber)
+	}
+	if config.MinNumber != DefaultConfig().MinNumber {
+		t.Errorf("Expected default min number, got %d", config.MinNumber)
+	}
+}
+
+func TestLoadConfigValidates(t *testing.T) {
+	invalid := [][]string{
+		{"-players-per-match", "1"},
+		{"-min-number", "10", "-max-number", "10"},
+		{"-cert-file", "cert.pem"},
+		{"-log-level", "loud"},
+		{"-overflow", "ignore"},
+		{"-ping-interval", "1m", "-pong-wait", "30s"},
+	}
+
+	for _, args := range invalid {
+		if _, err := LoadConfig(args); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestLoadConfigRejectsUnknownSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"players": 4}`), 0644)
+
+	if _, err := LoadConfig([]string{"-config", path}); err == nil {
+		t.Error("Expected unknown setting to be rejected")
+	}
+}
+
+func TestLoadConfigQueues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{
+		"default-queue": "blitz",
+		"queues": [
+			{"name": "blitz", "players": 3, "rules": {"time-limit": "1m", "max-number": 20}},
+			{"name": "duel", "matchmaking": "rating", "rules": {"mode": "turns"}}
+		]
+	}`), 0644)
+
+	config, err := LoadConfig([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("Expected config, got error: %v", err)
+	}
+
+	queues := config.Queues()
+	if len(queues) != 2 {
+		t.Fatalf("Expected the two queues of the file, got %+v", queues)
+	}
+
+	blitz, duel := queues[0], queues[1]
+	if blitz.Name != "blitz" || blitz.Players != 3 || blitz.Matchmaking != FIFO_STRATEGY {
+		t.Errorf("Expected a fifo queue of 3, got %+v", blitz)
+	}
+	if blitz.Rules.TimeLimit != time.Minute || blitz.Rules.Max != 20 || blitz.Rules.Min != config.MinNumber {
+		t.Errorf("Expected the rules of the queue over the config, got %+v", blitz.Rules)
+	}
+	if duel.Players != config.PlayersPerMatch || duel.Matchmaking != RATING_STRATEGY || !duel.Rules.TurnBased() {
+		t.Errorf("Expected a ranked turn-based queue, got %+v", duel)
+	}
+	if config.MaxNumber != DefaultConfig().MaxNumber {
+		t.Errorf("Expected the rules of a queue to leave the config alone, got %d", config.MaxNumber)
+	}
+
+	invalid := []string{
+		`[{"name": "blitz"}, {"name": "blitz"}]`,
+		`[{"name": "blitz", "players": 1}]`,
+		`[{"name": "blitz", "rules": {"addr": "0.0.0.0:80"}}]`,
+		`[{"name": "blitz", "rules": {"min-number": 5, "max-number": 5}}]`,
+		`[{"name": "blitz"}]`, // the default queue is gone
+	}
+
+	for _, queues := range invalid {
+		if _, err := LoadConfig([]string{"-queues", queues}); err == nil {
+			t.Errorf("Expected %s to be rejected", queues)
+		}
+	}
+}
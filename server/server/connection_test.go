@@ -1,17 +1,31 @@
 package server
 
 import (
+	"net/http"
 	"testing"
 	"time"
 
 	"example.com/game/client/client"
+	"example.com/game/client/protocol"
+	"github.com/gorilla/websocket"
 )
 
+// Defaults with a grace period of zero, so dropped clients are
+// disconnected right away, and a short confirmation timeout
+func testConfig() Config {
+	config := DefaultConfig()
+	config.GracePeriod = 0
+	config.ConfirmationTimeout = 100 * time.Millisecond
+	config.QueueStatusInterval = 0
+
+	return config
+}
+
 func TestAcceptsConnections(t *testing.T) {
-	server := NewServer([]EventHandler{})
+	server := NewServer(testConfig(), []EventHandler{})
 	defer server.Close()
 
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -31,3 +45,97 @@ func TestCloseServer(t *testing.T) {
 		t.Errorf("Expected error, got connection")
 	}
 }
+
+func TestMalformedMessageGetsErrorReply(t *testing.T) {
+	server := NewServer(testConfig(), []EventHandler{NewGameManager(testConfig())})
+	defer server.Close()
+
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://0.0.0.0:8080", nil)
+	if err != nil {
+		t.Fatalf("Expected connection, got error: %v", err)
+	}
+	defer conn.Close()
+
+	conn.ReadMessage() // session
+
+	frames := map[string]string{
+		`{"Type": "guess", "Payload": {"gameId": "x", "guess": "abc"}}`: protocol.INVALID_PAYLOAD,
+		`{"Type": "guess", "Payload": {"guess": 10}}`:                   protocol.INVALID_PAYLOAD,
+		`{"Type": "match_found"}`:                                       protocol.UNKNOWN_TYPE,
+		`not json`:                                                      protocol.MALFORMED,
+	}
+
+	for frame, code := range frames {
+		conn.WriteMessage(websocket.TextMessage, []byte(frame))
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected error reply, got %v", err)
+		}
+
+		reply, _ := protocol.Decode(data, protocol.ToClient)
+		if reply.Type != "error" {
+			t.Fatalf("Expected \"error\", got \"%s\"", reply.Type)
+		}
+		if reply.Payload.(*protocol.Error).Code != code {
+			t.Errorf("Expected code \"%s\" for %s, got \"%s\"", code, frame, reply.Payload.(*protocol.Error).Code)
+		}
+	}
+}
+
+func TestUnresponsivePeerIsDisconnected(t *testing.T) {
+	queueManager := NewQueueManager(testConfig())
+
+	config := testConfig()
+	config.Keepalive = protocol.Keepalive{
+		PingInterval: 20 * time.Millisecond,
+		PongWait:     50 * time.Millisecond,
+		WriteWait:    50 * time.Millisecond,
+	}
+
+	server := NewServer(config, []EventHandler{queueManager})
+
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	responsive := NewTestClient()
+	responsive.QueueUp()
+
+	time.Sleep(200 * time.Millisecond)
+
+	if queueManager.Count() != 1 {
+		t.Errorf("Expected responsive client to stay queued, got %d", queueManager.Count())
+	}
+
+	responsive.Client.Close()
+
+	// never reads again, so pings are not answered
+	unresponsive, _ := NewRawClient("")
+	defer unresponsive.Drop()
+
+	unresponsive.Write(Message{Type: "queue_up"})
+
+	time.Sleep(200 * time.Millisecond)
+
+	if queueManager.Count() != 0 {
+		t.Errorf("Expected unresponsive client to be dequeued, got %d", queueManager.Count())
+	}
+}
+
+func TestCloseWithoutListening(t *testing.T) {
+	NewServer(testConfig(), nil).Close()
+
+	server := NewServer(testConfig(), nil)
+	if err := server.Shutdown(0); err != nil {
+		t.Errorf("Expected shutdown without listening to succeed, got %v", err)
+	}
+	if err := server.Listen(); err != http.ErrServerClosed {
+		t.Errorf("Expected http.ErrServerClosed, got %v", err)
+	}
+}
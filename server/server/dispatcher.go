@@ -1,16 +1,184 @@
 package server
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DEFAULT_WORKERS    = 4
+	DEFAULT_QUEUE_SIZE = 256
+)
+
+// Dispatcher delivers events to every handler on a fixed pool of worker
+// goroutines.
+//
+// Events are sharded by socket, so all events of a given socket are
+// processed by the same worker in the order they were dispatched, while
+// events of different sockets are processed in parallel. Events without a
+// socket are spread across the workers in round-robin.
+//
+// Each worker has a bounded queue. Submit blocks while the queue is full and
+// is meant for connection readers, so a slow handler only throttles the
+// connections sharing its worker. Dispatch never blocks and is the only safe
+// way for handlers to re-enter the dispatcher: a handler waiting for room in
+// its own worker's queue would never be woken up. Instead, it may go past
+// the capacity by as much again, and drops the event once that is full too.
 type Dispatcher struct {
 	handlers []EventHandler
+	workers  []*worker
+	next     uint64
+	wg       *sync.WaitGroup
+}
+
+func NewDispatcher(handlers []EventHandler, workers int, capacity int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	dispatcher := &Dispatcher{
+		handlers: handlers,
+		workers:  make([]*worker, workers),
+		wg:       new(sync.WaitGroup),
+	}
+
+	for i := range dispatcher.workers {
+		dispatcher.workers[i] = newWorker(capacity)
+	}
+
+	return dispatcher
+}
+
+// Starts the workers, passing server along to the handlers
+func (d *Dispatcher) Start(server *Server) {
+	for _, worker := range d.workers {
+		d.wg.Add(1)
+		go worker.run(func(event Event) {
+			for _, handler := range d.handlers {
+				handler.Process(event, server)
+			}
+		}, d.wg)
+	}
+}
+
+// Enqueues the event without waiting for room in the queue. Returns false
+// if the dispatcher is closed or the event was dropped for lack of room.
+func (d *Dispatcher) Dispatch(event Event) bool {
+	return d.workerFor(event).push(event, false)
+}
+
+// Enqueues the event, waiting while the worker's queue is full.
+// Returns false if the dispatcher is closed.
+func (d *Dispatcher) Submit(event Event) bool {
+	return d.workerFor(event).push(event, true)
+}
+
+// Stops accepting events and waits for the queued ones to be processed
+func (d *Dispatcher) Close() {
+	for _, worker := range d.workers {
+		worker.close()
+	}
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) workerFor(event Event) *worker {
+	var key uint64
+
+	if event.Socket != nil {
+		key = event.Socket.id
+	} else {
+		key = atomic.AddUint64(&d.next, 1)
+	}
+
+	return d.workers[key%uint64(len(d.workers))]
+}
+
+type worker struct {
+	mutex    *sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+
+	events   []Event
+	capacity int
+	closed   bool
 }
 
-func NewDispatcher(handlers []EventHandler) *Dispatcher {
-	return &Dispatcher{handlers}
+func newWorker(capacity int) *worker {
+	mutex := new(sync.Mutex)
+
+	return &worker{
+		mutex:    mutex,
+		notEmpty: sync.NewCond(mutex),
+		notFull:  sync.NewCond(mutex),
+		events:   make([]Event, 0, capacity),
+		capacity: capacity,
+	}
 }
 
-func (d *Dispatcher) Dispatch(event Event) {
-	/*
-		for _, handler := range d.handlers {
-			handler.Process(event)
-		}*/
+func (w *worker) push(event Event, wait bool) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for wait && !w.closed && len(w.events) >= w.capacity {
+		w.notFull.Wait()
+	}
+
+	if w.closed {
+		return false
+	}
+
+	// room past the capacity is only there for handlers re-entering
+	if len(w.events) >= 2*w.capacity {
+		Warnf("Dropped \"%s\" event, the queue is full", event.Type)
+		return false
+	}
+
+	w.events = append(w.events, event)
+	w.notEmpty.Signal()
+
+	return true
+}
+
+func (w *worker) pop() (Event, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for len(w.events) == 0 && !w.closed {
+		w.notEmpty.Wait()
+	}
+
+	// closed and drained
+	if len(w.events) == 0 {
+		return Event{}, false
+	}
+
+	event := w.events[0]
+	w.events[0] = Event{}
+	w.events = w.events[1:]
+	w.notFull.Broadcast()
+
+	return event, true
+}
+
+func (w *worker) run(process func(event Event), wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		event, ok := w.pop()
+
+		if !ok {
+			return
+		}
+
+		process(event)
+	}
+}
+
+func (w *worker) close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.closed = true
+	w.notEmpty.Broadcast()
+	w.notFull.Broadcast()
 }
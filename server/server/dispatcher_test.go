@@ -0,0 +1,124 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type RecordingHandler struct {
+	mutex  *sync.Mutex
+	events map[*Socket][]string
+}
+
+func (h *RecordingHandler) Process(event Event, server *Server) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.events[event.Socket] = append(h.events[event.Socket], event.Type)
+}
+
+func (h *RecordingHandler) Get(socket *Socket) []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.events[socket]
+}
+
+type SlowHandler struct {
+	release chan bool
+}
+
+func (h *SlowHandler) Process(event Event, server *Server) {
+	if event.Type == "slow" {
+		<-h.release
+	}
+}
+
+func TestDispatchKeepsSocketOrder(t *testing.T) {
+	recorder := &RecordingHandler{new(sync.Mutex), make(map[*Socket][]string)}
+	dispatcher := NewDispatcher([]EventHandler{recorder}, 4, 8)
+	dispatcher.Start(nil)
+
+	socket := NewSocket(&websocket.Conn{})
+	expected := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+
+	for _, eventType := range expected {
+		dispatcher.Submit(Event{Type: eventType, Socket: socket})
+	}
+
+	dispatcher.Close()
+
+	events := recorder.Get(socket)
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d", len(expected), len(events))
+	}
+
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected \"%s\" at %d, got \"%s\"", expected[i], i, events[i])
+		}
+	}
+}
+
+func TestSlowHandlerDoesNotBlockOtherSockets(t *testing.T) {
+	slow := &SlowHandler{make(chan bool)}
+	recorder := &RecordingHandler{new(sync.Mutex), make(map[*Socket][]string)}
+
+	dispatcher := NewDispatcher([]EventHandler{slow, recorder}, 2, 8)
+	dispatcher.Start(nil)
+
+	first := NewSocket(&websocket.Conn{})
+	second := NewSocket(&websocket.Conn{})
+
+	dispatcher.Submit(Event{Type: "slow", Socket: first})
+	dispatcher.Submit(Event{Type: "fast", Socket: second})
+
+	time.Sleep(10 * time.Millisecond)
+
+	if len(recorder.Get(second)) != 1 {
+		t.Error("Expected event of other socket to be processed")
+	}
+
+	slow.release <- true
+	dispatcher.Close()
+
+	if len(recorder.Get(first)) != 1 {
+		t.Error("Expected slow event to be processed before closing")
+	}
+}
+
+func TestDispatchAfterClose(t *testing.T) {
+	dispatcher := NewDispatcher([]EventHandler{}, 1, 1)
+	dispatcher.Start(nil)
+	dispatcher.Close()
+
+	if dispatcher.Dispatch(Event{Type: "late"}) {
+		t.Error("Expected closed dispatcher to reject events")
+	}
+}
+
+func TestDispatchOverflowIsBounded(t *testing.T) {
+	slow := &SlowHandler{make(chan bool)}
+	dispatcher := NewDispatcher([]EventHandler{slow}, 1, 2)
+	dispatcher.Start(nil)
+
+	// keeps the worker busy while its queue fills up
+	dispatcher.Submit(Event{Type: "slow"})
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		if !dispatcher.Dispatch(Event{Type: "fast"}) {
+			t.Errorf("Expected full queue to still accept event %d", i)
+		}
+	}
+
+	if dispatcher.Dispatch(Event{Type: "fast"}) {
+		t.Error("Expected event to be dropped once the overflow is full")
+	}
+
+	slow.release <- true
+	dispatcher.Close()
+}
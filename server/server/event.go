@@ -1,19 +1,90 @@
 package server
 
+import (
+	"time"
+
+	"example.com/game/client/protocol"
+)
+
 type Event struct {
-	Type    string
-	Payload map[string]interface{}
-	Socket  *Socket
+	Type      string
+	Payload   interface{}
+	Socket    *Socket
+	RequestId string
+}
+
+// Payload of the internal "match_found" event
+type MatchFoundPayload struct {
+	Players []*Socket
+	Queue   string
+	Rules   GameRules
+}
+
+// Payload of the internal "game_start" event
+type GameStartPayload struct {
+	Players *Sockets
+	Rules   GameRules
+}
+
+// Payload of the internal "game_over" event, with the place each player
+// finished in
+type GameOverPayload struct {
+	Places map[*Socket]int
+}
+
+// Payload of the internal "party_changed" event, with everyone who was or
+// is in the party
+type PartyChangedPayload struct {
+	Members []*Socket
+}
+
+// Payload of the internal "queue_status" event, with when the entry to
+// report on queued up, so reports stop once it queues up again
+type QueueStatusPayload struct {
+	Joined time.Time
 }
 
 type EventHandler interface {
 	Process(event Event, server *Server)
 }
 
+// Drainer is implemented by handlers holding state a graceful shutdown
+// should wait for, e.g. running games
+type Drainer interface {
+	Pending() int
+}
+
 func NewEvent(msg Message, socket *Socket) Event {
 	return Event{
-		Type:    msg.Type,
-		Payload: msg.Payload,
-		Socket:  socket,
+		Type:      msg.Type,
+		Payload:   msg.Payload,
+		Socket:    socket,
+		RequestId: msg.RequestId,
+	}
+}
+
+// Tells the sender the request was accepted. Clients that don't correlate
+// requests leave RequestId empty and get no ack.
+func (e Event) Ack() {
+	if e.Socket == nil || e.RequestId == "" {
+		return
 	}
+
+	e.Socket.Send(Message{
+		Type:      "ack",
+		RequestId: e.RequestId,
+	})
+}
+
+// Tells the sender the request was rejected and why
+func (e Event) Reject(code string, message string) {
+	if e.Socket == nil {
+		return
+	}
+
+	e.Socket.Send(Message{
+		Type:      "error",
+		Payload:   protocol.NewError(code, message),
+		RequestId: e.RequestId,
+	})
 }
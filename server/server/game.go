@@ -2,93 +2,800 @@ package server
 
 import (
 	"fmt"
-	"math/rand"
+	"sort"
 	"sync"
+	"time"
+
+	"example.com/game/client/protocol"
 )
 
+type GameRules = protocol.GameRules
+
 type Game struct {
-	mutex *sync.Mutex
+	mutex  *sync.Mutex
+	timer  *time.Timer
+	expire func()
 
-	Id      int
+	Id      string
+	Rules   GameRules
+	Variant Variant
 	Answer  int
 	Done    bool
 	Players *Sockets
+	Series  *Series
+	// first player to guess it, nil if nobody did
+	Winner *Socket
+
+	// per player answers, when the rules don't share one
+	Answers map[*Socket]int
+	Guesses map[*Socket]int
+
+	// players who guessed their number, in the order they did
+	Placements []*Socket
+
+	// index of the player whose turn it is in turn-based games
+	Turn int
+	// consecutive turns each player missed
+	Missed    map[*Socket]int
+	Forfeited map[*Socket]bool
+	turnTimer *time.Timer
+	// bumped every turn, so a stale turn timer is ignored
+	turns int
+
+	// players who disconnected, who get no more messages
+	Left map[*Socket]bool
+	// players who offered or accepted a draw
+	DrawOffers map[*Socket]bool
+
+	// secrets picked by the players in duels, each guessing the next one's
+	Secrets     map[*Socket]int
+	Choosing    bool
+	secretTimer *time.Timer
 }
 
-func NewGame(players *Sockets) *Game {
-	return &Game{
+// Creates a game whose answer is drawn by the variant of the rules
+func NewGame(players *Sockets, rules GameRules) *Game {
+	variant := VariantFor(rules)
+
+	game := &Game{
 		mutex: new(sync.Mutex),
 
-		Id:      rand.Intn(1000),
+		Id:      NewId(),
+		Rules:   rules,
+		Variant: variant,
 		Done:    false,
 		Players: players,
-		Answer:  rand.Intn(100),
+		Answer:  variant.Draw(rules),
+		Answers: make(map[*Socket]int),
+		Guesses: make(map[*Socket]int),
+
+		Missed:    make(map[*Socket]int),
+		Forfeited: make(map[*Socket]bool),
+		Secrets:   make(map[*Socket]int),
+
+		Left:       make(map[*Socket]bool),
+		DrawOffers: make(map[*Socket]bool),
 	}
+
+	if !rules.SharedAnswer && !rules.Duel {
+		for _, player := range players.conns {
+			game.Answers[player] = variant.Draw(rules)
+		}
+	}
+
+	return game
 }
 
-func (g *Game) Start() {
+// Sends the rules to the players and starts the first turn, if the game
+// is turn-based, or the selection of secrets in duels. expire is called
+// after the game ends on its own, once time is up or every other player
+// forfeited.
+func (g *Game) Start(expire func()) {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	// send guess for both players
+	g.expire = expire
+
+	// send guess for every player
+	for i, player := range g.Players.conns {
+		player.Send(Message{
+			Type: "guess",
+			Payload: protocol.GameStart{
+				GameId:  g.Id,
+				Rules:   g.Rules,
+				Player:  i + 1,
+				Players: len(g.Players.conns),
+			},
+		})
+	}
+
+	if g.Rules.Duel {
+		g.Choosing = true
+		g.secretTimer = time.AfterFunc(g.Rules.SecretTime, func() {
+			g.mutex.Lock()
+			defer g.mutex.Unlock()
+
+			if g.Choosing && !g.Done {
+				g.CloseSecrets()
+			}
+		})
+
+		g.Players.Send(Message{
+			Type: "choose_secret",
+			Payload: protocol.ChooseSecret{
+				Deadline: time.Now().Add(g.Rules.SecretTime),
+			},
+		})
+		return
+	}
+
+	g.begin()
+}
+
+// Starts the clock and the first turn once the answers are set
+func (g *Game) begin() {
+	if g.Rules.TimeLimit > 0 {
+		g.timer = time.AfterFunc(g.Rules.TimeLimit, func() {
+			g.mutex.Lock()
+			done := g.Done
+
+			if !done && len(g.Placements) > 0 {
+				g.Conclude()
+			} else if !done {
+				g.Draw("Time is up. It's a draw!")
+			}
+			g.mutex.Unlock()
+
+			if !done && g.expire != nil {
+				g.expire()
+			}
+		})
+	}
+
+	if g.Rules.TurnBased() {
+		g.Turn = len(g.Players.conns) - 1
+		g.NextTurn()
+	}
+}
+
+// Keeps the secret the player picked in a duel and starts guessing once
+// everyone did. Returns false if secrets can't be picked anymore.
+func (g *Game) ChooseSecret(player *Socket, secret int) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.Choosing || g.Done {
+		return false
+	}
+
+	g.Secrets[player] = secret
+
+	if len(g.Secrets) == len(g.Players.conns) {
+		g.CloseSecrets()
+	}
+	return true
+}
+
+// Ends the selection of secrets, drawing one for players who didn't pick
+// theirs in time, and tells each player whose secret they have to guess
+func (g *Game) CloseSecrets() {
+	g.Choosing = false
+
+	if g.secretTimer != nil {
+		g.secretTimer.Stop()
+	}
+
+	drawn := make(map[*Socket]bool)
+	for _, player := range g.Players.conns {
+		if _, ok := g.Secrets[player]; !ok {
+			g.Secrets[player] = g.Variant.Draw(g.Rules)
+			drawn[player] = true
+		}
+	}
+
+	for i, player := range g.Players.conns {
+		target := g.Players.conns[(i+1)%len(g.Players.conns)]
+		g.Answers[player] = g.Secrets[target]
+
+		player.Send(Message{
+			Type: "secrets_chosen",
+			Payload: protocol.SecretsChosen{
+				Secret:   g.Secrets[player],
+				Drawn:    drawn[player],
+				Opponent: g.number(target),
+			},
+		})
+	}
+
+	g.begin()
+}
+
+// Whether players are still picking their secrets
+func (g *Game) ChoosingSecrets() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.Choosing
+}
+
+// Player whose secret is the answer of the given one in duels
+func (g *Game) owner(player *Socket) *Socket {
+	for i, p := range g.Players.conns {
+		if p == player {
+			return g.Players.conns[(i+1)%len(g.Players.conns)]
+		}
+	}
+	return nil
+}
+
+// Whether the player may guess now, always true unless the game is
+// turn-based
+func (g *Game) CanGuess(player *Socket) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return !g.Rules.TurnBased() || g.Players.conns[g.Turn] == player
+}
+
+// Whether the player can still take a turn
+func (g *Game) playing(player *Socket) bool {
+	return !g.Placed(player) && !g.Forfeited[player] && g.Remaining(player) != 0
+}
+
+// Passes the turn to the next player still playing and starts its countdown
+func (g *Game) NextTurn() {
+	count := len(g.Players.conns)
+
+	for i := 1; i <= count; i++ {
+		next := (g.Turn + i) % count
+
+		if g.playing(g.Players.conns[next]) {
+			g.Turn = next
+			break
+		}
+	}
+
+	g.turns++
+	turn := g.turns
+
+	if g.turnTimer != nil {
+		g.turnTimer.Stop()
+	}
+	g.turnTimer = time.AfterFunc(g.Rules.TurnTime, func() {
+		g.MissTurn(turn)
+	})
+
+	g.Players.Send(Message{
+		Type: "turn",
+		Payload: protocol.Turn{
+			Player:   g.Turn + 1,
+			Deadline: time.Now().Add(g.Rules.TurnTime),
+		},
+	})
+}
+
+// Skips the turn whose countdown ran out, forfeiting the player once they
+// missed too many in a row
+func (g *Game) MissTurn(turn int) {
+	g.mutex.Lock()
+
+	if g.Done || turn != g.turns {
+		g.mutex.Unlock()
+		return
+	}
+
+	player := g.Players.conns[g.Turn]
+	g.Missed[player]++
+
+	forfeited := g.Rules.MaxMissedTurns > 0 && g.Missed[player] >= g.Rules.MaxMissedTurns
+	if forfeited {
+		g.Forfeited[player] = true
+	}
+
 	g.Players.Send(Message{
-		Type: "guess",
-		Payload: map[string]interface{}{
-			"GameId": g.Id,
+		Type: "turn_missed",
+		Payload: protocol.TurnMissed{
+			Player:    g.Turn + 1,
+			Forfeited: forfeited,
 		},
 	})
+
+	over := g.settle()
+	if !over {
+		g.NextTurn()
+	}
+
+	g.mutex.Unlock()
+
+	if over && g.expire != nil {
+		g.expire()
+	}
+}
+
+func (g *Game) AnswerFor(player *Socket) int {
+	if answer, ok := g.Answers[player]; ok {
+		return answer
+	}
+	return g.Answer
+}
+
+// Returns how many guesses the player has left, -1 meaning unlimited
+func (g *Game) Remaining(player *Socket) int {
+	if g.Rules.MaxGuesses == 0 {
+		return -1
+	}
+	return g.Rules.MaxGuesses - g.Guesses[player]
 }
 
+// Whether the player already guessed their number
+func (g *Game) Placed(player *Socket) bool {
+	for _, placed := range g.Placements {
+		if placed == player {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the guess and tells whether the game is over
 func (g *Game) CheckGuess(guess int, player *Socket) bool {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	// if guess = Answer, end game
-	if guess == g.Answer {
-		g.End(player)
+	if g.Done {
 		return true
+	}
+
+	if g.Placed(player) || g.Forfeited[player] {
+		return false
+	}
+
+	if g.Choosing || g.Rules.TurnBased() && g.Players.conns[g.Turn] != player {
+		return false
+	}
+
+	if g.Remaining(player) == 0 {
+		g.OutOfGuesses(player)
+		return false
+	}
+
+	g.Guesses[player]++
+
+	if g.Rules.TurnBased() {
+		g.Missed[player] = 0
+		g.Reveal(guess, player)
+	} else if g.Rules.Duel {
+		g.RevealTo(g.owner(player), guess, player)
+	}
+
+	// if guess = Answer, the first one wins or, when the rules keep the
+	// game going, the player takes the next place
+	if guess == g.AnswerFor(player) {
+		g.Placements = append(g.Placements, player)
+
+		if !g.Rules.KeepPlaying {
+			g.End(player)
+			return true
+		}
+
+		g.Finished(player)
 	} else {
 		// otherwise, respond with > or <
 		g.Feedback(guess, player)
+
+		if g.Remaining(player) == 0 {
+			g.OutOfGuesses(player)
+		}
+	}
+
+	if g.settle() {
+		return true
+	}
+
+	if g.Rules.TurnBased() {
+		g.NextTurn()
+	}
+	return false
+}
+
+// Ends the game if nobody is left to guess, or a single player is left
+// after everyone else forfeited
+func (g *Game) settle() bool {
+	if !g.Rules.KeepPlaying && len(g.Forfeited) > 0 {
+		remaining := make([]*Socket, 0)
+
+		for _, player := range g.Players.conns {
+			if !g.Forfeited[player] {
+				remaining = append(remaining, player)
+			}
+		}
+
+		if len(remaining) == 1 {
+			g.win(remaining[0], "You won. Everyone else forfeited.")
+			return true
+		}
+	}
+
+	if !g.Settled() {
 		return false
 	}
+
+	if len(g.Placements) == 0 {
+		g.Draw("Nobody guessed it. It's a draw!")
+	} else {
+		g.Conclude()
+	}
+	return true
+}
+
+// Whether every player either guessed it, used up their guesses or
+// forfeited
+func (g *Game) Settled() bool {
+	for _, player := range g.Players.conns {
+		if g.playing(player) {
+			return false
+		}
+	}
+	return true
 }
 
+func (g *Game) finish() {
+	g.Done = true
+
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	if g.turnTimer != nil {
+		g.turnTimer.Stop()
+	}
+	if g.secretTimer != nil {
+		g.secretTimer.Stop()
+	}
+}
+
+// Stops the game and its timers without a result
+func (g *Game) Abort() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.finish()
+}
+
+// Ends the game on the first correct guess, everyone else loses
 func (g *Game) End(winner *Socket) {
+	g.win(winner, "Correct! You won!")
+}
+
+func (g *Game) win(winner *Socket, message string) {
+	g.finish()
+	g.Winner = winner
+
 	for _, player := range g.Players.conns {
-		if player.conn != winner.conn {
+		if g.Left[player] {
+			continue
+		}
+
+		if player != winner {
 			// send loss to loser
 			player.Send(Message{
 				Type: "loss",
-				Payload: map[string]interface{}{
-					"message": fmt.Sprintf("You lost. The number was %d", g.Answer),
+				Payload: protocol.Loss{
+					Message: fmt.Sprintf("You lost. The answer was %s", g.Rules.Format(g.AnswerFor(player))),
 				},
 			})
 		} else {
 			// send victory to winner
 			winner.Send(Message{
 				Type: "victory",
-				Payload: map[string]interface{}{
-					"message": "Correct! You won!",
+				Payload: protocol.Victory{
+					Message: message,
 				},
 			})
 		}
 	}
+
+	g.SendStandings()
 }
 
-func (g *Game) Feedback(guess int, player *Socket) {
-	feedback := ""
+// Ends the game once everyone kept playing until they were placed or
+// ran out of guesses
+func (g *Game) Conclude() {
+	g.finish()
+	g.Winner = g.Placements[0]
+	g.SendStandings()
+}
+
+func (g *Game) Draw(reason string) {
+	g.finish()
+
+	g.broadcast(Message{
+		Type: "draw",
+		Payload: protocol.Draw{
+			Message: reason,
+		},
+	})
+
+	g.SendStandings()
+}
+
+// Sends the message to the players still connected
+func (g *Game) broadcast(msg Message) {
+	for _, player := range g.Players.conns {
+		if !g.Left[player] {
+			player.Send(msg)
+		}
+	}
+}
+
+// The player gives up. Returns whether the game is over.
+func (g *Game) Forfeit(player *Socket) bool {
+	return g.withdraw(player, false)
+}
+
+// The player disconnected, which counts as forfeiting. Returns whether
+// the game is over.
+func (g *Game) Leave(player *Socket) bool {
+	return g.withdraw(player, true)
+}
+
+// Takes the player out of the game. The last one standing wins, unless
+// the rules keep the game going for them to be ranked.
+func (g *Game) withdraw(player *Socket, left bool) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.Done {
+		return true
+	}
+
+	if left {
+		g.Left[player] = true
+	}
+	if g.Forfeited[player] {
+		return false
+	}
+	g.Forfeited[player] = true
+
+	remaining := make([]*Socket, 0)
+	for _, p := range g.Players.conns {
+		if !g.Forfeited[p] {
+			remaining = append(remaining, p)
+		}
+	}
+
+	if !g.Rules.KeepPlaying && len(remaining) == 1 {
+		if left {
+			g.win(remaining[0], "You won. The other player disconnected.")
+		} else {
+			g.win(remaining[0], "You won. The other player forfeited.")
+		}
+		return true
+	}
+
+	if g.settle() {
+		return true
+	}
+
+	if !left {
+		player.Send(Message{
+			Type: "loss",
+			Payload: protocol.Loss{
+				Message: "You forfeited.",
+			},
+		})
+		g.sendStandingsTo(player)
+	}
+
+	for _, other := range remaining {
+		other.Send(Message{
+			Type: "player_forfeited",
+			Payload: protocol.PlayerForfeited{
+				Player: g.number(player),
+				Left:   left,
+			},
+		})
+	}
+
+	if g.Rules.TurnBased() && !g.Choosing && g.Players.conns[g.Turn] == player {
+		g.NextTurn()
+	}
+
+	return false
+}
+
+// Counts the player in for a draw, which happens once every player still
+// playing agreed. Returns whether the game is over.
+func (g *Game) OfferDraw(player *Socket) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.Done {
+		return true
+	}
+	if g.DrawOffers[player] {
+		return false
+	}
+	g.DrawOffers[player] = true
+
+	agreed := true
+	for _, p := range g.Players.conns {
+		if g.Forfeited[p] || g.Placed(p) || g.DrawOffers[p] {
+			continue
+		}
+
+		agreed = false
+		p.Send(Message{
+			Type: "draw_offered",
+			Payload: protocol.DrawOffered{
+				Player: g.number(player),
+			},
+		})
+	}
 
-	if guess < g.Answer {
-		feedback = "Try a greater number"
+	if !agreed {
+		return false
+	}
+
+	if len(g.Placements) > 0 {
+		g.Conclude()
 	} else {
-		feedback = "Try a smaller number"
+		g.Draw("Players agreed to a draw.")
+	}
+	return true
+}
+
+// Whether anybody offered a draw
+func (g *Game) DrawOffered() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return len(g.DrawOffers) > 0
+}
+
+// Ranks players by the order they guessed it, the ones who didn't share
+// the place after the last of them
+func (g *Game) Standings() []protocol.Standing {
+	standings := make([]protocol.Standing, 0, len(g.Players.conns))
+	places := g.Places()
+
+	for i, player := range g.Players.conns {
+		standings = append(standings, protocol.Standing{
+			Player:  i + 1,
+			Place:   places[player],
+			Guesses: g.Guesses[player],
+			Solved:  g.Placed(player),
+		})
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Place < standings[j].Place
+	})
+
+	return standings
+}
+
+// Place of every player: those who guessed it in the order they did, then
+// a winner by forfeit, then everyone else tied, with forfeits last
+func (g *Game) Places() map[*Socket]int {
+	places := make(map[*Socket]int)
+
+	for i, player := range g.Placements {
+		places[player] = i + 1
+	}
+
+	next := len(g.Placements) + 1
+	if g.Winner != nil && !g.Placed(g.Winner) {
+		places[g.Winner] = next
+		next++
+	}
+
+	unplaced := false
+	for _, player := range g.Players.conns {
+		if _, ok := places[player]; !ok && !g.Forfeited[player] {
+			places[player] = next
+			unplaced = true
+		}
+	}
+	if unplaced {
+		next++
+	}
+
+	for _, player := range g.Players.conns {
+		if _, ok := places[player]; !ok {
+			places[player] = next
+		}
+	}
+
+	return places
+}
+
+// Broadcasts the final standings, telling each player which one they are
+func (g *Game) SendStandings() {
+	for _, player := range g.Players.conns {
+		if !g.Left[player] {
+			g.sendStandingsTo(player)
+		}
 	}
+}
+
+func (g *Game) sendStandingsTo(player *Socket) {
+	player.Send(Message{
+		Type: "standings",
+		Payload: protocol.Standings{
+			GameId:    g.Id,
+			You:       g.number(player),
+			Standings: g.Standings(),
+		},
+	})
+}
+
+// Tells the player they guessed it while the others keep playing
+func (g *Game) Finished(player *Socket) {
+	place := len(g.Placements)
+
+	player.Send(Message{
+		Type: "finished",
+		Payload: protocol.Finished{
+			Place:   place,
+			Message: fmt.Sprintf("Correct! You finished %s. Waiting for the other players...", protocol.Ordinal(place)),
+		},
+	})
+}
+
+// Tells the player they can't guess anymore. They lose if somebody
+// else guesses it and draw if nobody does.
+func (g *Game) OutOfGuesses(player *Socket) {
+	player.Send(Message{
+		Type: "out_of_guesses",
+		Payload: protocol.OutOfGuesses{
+			Message: "You are out of guesses. Waiting for the other players...",
+		},
+	})
+}
 
+func (g *Game) Feedback(guess int, player *Socket) {
 	player.Send(Message{
 		Type: "feedback",
-		Payload: map[string]interface{}{
-			"message": feedback,
+		Payload: protocol.Feedback{
+			Message:   g.hint(guess, player),
+			Remaining: g.Remaining(player),
+		},
+	})
+}
+
+func (g *Game) hint(guess int, player *Socket) string {
+	if guess == g.AnswerFor(player) {
+		return "Correct!"
+	}
+	return g.Variant.Hint(guess, g.AnswerFor(player), g.Rules)
+}
+
+// Shows the guess and its feedback to the other players
+func (g *Game) Reveal(guess int, player *Socket) {
+	for _, other := range g.Players.conns {
+		if other != player {
+			g.RevealTo(other, guess, player)
+		}
+	}
+}
+
+func (g *Game) RevealTo(other *Socket, guess int, player *Socket) {
+	other.Send(Message{
+		Type: "opponent_guess",
+		Payload: protocol.OpponentGuess{
+			Player:  g.number(player),
+			Guess:   guess,
+			Message: g.hint(guess, player),
 		},
 	})
 }
+
+// Number of the player as told in the start of the game
+func (g *Game) number(player *Socket) int {
+	for i, p := range g.Players.conns {
+		if p == player {
+			return i + 1
+		}
+	}
+	return 0
+}
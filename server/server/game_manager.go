@@ -3,55 +3,184 @@ package server
 import (
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
+
+	"example.com/game/client/protocol"
 )
 
 type GameManager struct {
-	Games map[int]*Game
-	mut   *sync.Mutex
+	Games     map[string]*Game
+	Rematches map[string]*Rematch
+	mut       *sync.Mutex
+
+	bestOf         int
+	rematchTimeout time.Duration
+	draining       bool
 }
 
-func NewGameManager() *GameManager {
+func NewGameManager(config Config) *GameManager {
 	return &GameManager{
-		Games: make(map[int]*Game),
-		mut:   new(sync.Mutex),
+		Games:     make(map[string]*Game),
+		Rematches: make(map[string]*Rematch),
+		mut:       new(sync.Mutex),
+
+		bestOf:         config.BestOf,
+		rematchTimeout: config.RematchTimeout,
 	}
 }
 
-func (g *GameManager) AddGame(players *Sockets) *Game {
+func (g *GameManager) AddGame(rules GameRules, players *Sockets) *Game {
 	g.mut.Lock()
 	defer g.mut.Unlock()
 
-	game := NewGame(players)
+	game := NewGame(players, rules)
 	g.Games[game.Id] = game
 
 	return game
 }
 
-func (g *GameManager) RemoveGame(game *Game) {
-	g.mut.Lock()
-	defer g.mut.Unlock()
+// Starts the next game of the series
+func (g *GameManager) StartRound(series *Series, server *Server) *Game {
+	game := g.AddGame(series.Rules, series.Players)
+	game.Series = series
 
-	game.Players.Send(Message{
-		Type: "victory",
-		Payload: map[string]interface{}{
-			"message": "You won. The other player disconnected.",
+	game.Start(func() {
+		g.EndGame(game, server)
+	})
+
+	return game
+}
+
+// Removes the finished game, has its players rated and either starts the
+// next round of its series or offers a rematch once the series is over
+func (g *GameManager) EndGame(game *Game, server *Server) {
+	// late guesses, forfeits and the timer may all see the game done
+	if !g.RemoveGame(game) {
+		return
+	}
+
+	server.Dispatch(Event{
+		Type: "game_over",
+		Payload: &GameOverPayload{
+			Places: game.Places(),
 		},
 	})
 
+	if game.Series == nil || game.Series.Abandoned() {
+		return
+	}
+
+	over := game.Series.Record(game.Winner)
+
+	if g.Draining() {
+		return
+	}
+
+	if !over {
+		g.StartRound(game.Series, server)
+		return
+	}
+
+	g.OfferRematch(game.Series)
+}
+
+func (g *GameManager) OfferRematch(series *Series) {
+	rematch := NewRematch(series)
+
+	g.mut.Lock()
+	g.Rematches[rematch.Id] = rematch
+	g.mut.Unlock()
+
+	rematch.Offer(g.rematchTimeout, func() {
+		g.CancelRematch(rematch)
+	})
+}
+
+func (g *GameManager) FindRematch(id string) (*Rematch, error) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	rematch, ok := g.Rematches[id]
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Rematch with ID %s not found", id))
+	}
+	return rematch, nil
+}
+
+func (g *GameManager) FindRematchWithSocket(socket *Socket) *Rematch {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	for _, rematch := range g.Rematches {
+		if rematch.Players.Has(socket) {
+			return rematch
+		}
+	}
+	return nil
+}
+
+// Removes the rematch, returning false if it was already gone
+func (g *GameManager) RemoveRematch(rematch *Rematch) bool {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if _, ok := g.Rematches[rematch.Id]; !ok {
+		return false
+	}
+
+	delete(g.Rematches, rematch.Id)
+	return true
+}
+
+func (g *GameManager) CancelRematch(rematch *Rematch) {
+	if g.RemoveRematch(rematch) {
+		rematch.Cancel()
+	}
+}
+
+// Cancels rematches and keeps series from starting new rounds
+func (g *GameManager) Drain() {
+	g.mut.Lock()
+	g.draining = true
+	rematches := g.Rematches
+	g.Rematches = make(map[string]*Rematch)
+	g.mut.Unlock()
+
+	for _, rematch := range rematches {
+		rematch.Cancel()
+	}
+}
+
+func (g *GameManager) Draining() bool {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	return g.draining
+}
+
+// Removes the game, returning false if it was already gone
+func (g *GameManager) RemoveGame(game *Game) bool {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if _, ok := g.Games[game.Id]; !ok {
+		return false
+	}
+
 	delete(g.Games, game.Id)
+	return true
 }
 
-func (g *GameManager) FindGame(id int) (*Game, error) {
+func (g *GameManager) FindGame(id string) (*Game, error) {
 	g.mut.Lock()
 	defer g.mut.Unlock()
 
 	game, ok := g.Games[id]
 
 	if !ok {
-		return nil, errors.New(fmt.Sprintf("Game with ID %d not found", id))
+		return nil, errors.New(fmt.Sprintf("Game with ID %s not found", id))
 	}
 	return game, nil
 }
@@ -69,32 +198,194 @@ func (g *GameManager) FindGameWithSocket(socket *Socket) *Game {
 	return nil
 }
 
+// Whether the socket is playing a game
+func (g *GameManager) Busy(socket *Socket) bool {
+	return g.FindGameWithSocket(socket) != nil
+}
+
+// Running games a graceful shutdown waits for
+func (g *GameManager) Pending() int {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	return len(g.Games)
+}
+
+// Ends every running game without a winner
+func (g *GameManager) AbortAll(reason string) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	for id, game := range g.Games {
+		game.Abort()
+		game.Players.Send(Message{
+			Type: "game_aborted",
+			Payload: protocol.GameAborted{
+				Message: reason,
+			},
+		})
+
+		delete(g.Games, id)
+	}
+}
+
 func (g *GameManager) Process(event Event, server *Server) {
 	switch event.Type {
+	case "draining":
+		g.Drain()
+
+	case "shutdown":
+		g.AbortAll("The server is shutting down. Game aborted.")
+
 	case "disconnected":
 		game := g.FindGameWithSocket(event.Socket)
 
-		if game != nil {
-			g.RemoveGame(game)
+		// the series can't go on without the player
+		if game != nil && game.Series != nil {
+			game.Series.Abandon()
+		}
+		if game != nil && game.Leave(event.Socket) {
+			g.EndGame(game, server)
+		}
+
+		if rematch := g.FindRematchWithSocket(event.Socket); rematch != nil {
+			g.CancelRematch(rematch)
 		}
 
 	case "game_start":
-		players := event.Payload["players"].(*Sockets)
-		game := g.AddGame(players)
-		game.Start()
+		payload := event.Payload.(*GameStartPayload)
+		g.StartRound(NewSeries(payload.Players, payload.Rules, g.bestOf), server)
 
 	case "guess":
-		// get game
-		gameId := int(event.Payload["gameId"].(float64))
+		payload := event.Payload.(*protocol.Guess)
+		game, err := g.FindGame(payload.GameId)
+
+		if err != nil {
+			event.Reject(protocol.GAME_NOT_FOUND, err.Error())
+			return
+		}
+
+		if !Authorize(event, game.Players, "game", game.Id) {
+			return
+		}
+
+		if game.ChoosingSecrets() {
+			event.Reject(protocol.NOT_STARTED, "Players are still choosing their secrets")
+			return
+		}
+
+		if !game.CanGuess(event.Socket) {
+			event.Reject(protocol.NOT_YOUR_TURN, "It's not your turn")
+			return
+		}
+
+		if err := game.Variant.Validate(payload.Guess, game.Rules); err != nil {
+			event.Reject(protocol.INVALID_GUESS, err.Error())
+			return
+		}
+
+		event.Ack()
+
+		if game.CheckGuess(payload.Guess, event.Socket) {
+			g.EndGame(game, server)
+		}
+
+	case "forfeit", "offer_draw", "accept_draw":
+		payload := event.Payload.(*protocol.GameAction)
+		game, err := g.FindGame(payload.GameId)
+
+		if err != nil {
+			event.Reject(protocol.GAME_NOT_FOUND, err.Error())
+			return
+		}
+
+		if !Authorize(event, game.Players, "game", game.Id) {
+			return
+		}
+
+		if event.Type == "accept_draw" && !game.DrawOffered() {
+			event.Reject(protocol.NO_DRAW_OFFER, "Nobody offered a draw")
+			return
+		}
+
+		event.Ack()
 
-		game, err := g.FindGame(gameId)
+		over := false
+		if event.Type == "forfeit" {
+			over = game.Forfeit(event.Socket)
+		} else {
+			over = game.OfferDraw(event.Socket)
+		}
+
+		if over {
+			g.EndGame(game, server)
+		}
+
+	case "rematch_accepted":
+		payload := event.Payload.(*protocol.RematchAccepted)
+		rematch, err := g.FindRematch(payload.RematchId)
+
+		if err != nil {
+			event.Reject(protocol.REMATCH_NOT_FOUND, err.Error())
+			return
+		}
+
+		if !Authorize(event, rematch.Players, "rematch", rematch.Id) {
+			return
+		}
 
-		if err == nil {
-			guess, _ := strconv.Atoi(strings.TrimSpace(event.Payload["guess"].(string)))
+		event.Ack()
 
-			if game.CheckGuess(guess, event.Socket) {
-				g.RemoveGame(game)
-			}
+		if rematch.Accept(event.Socket) && g.RemoveRematch(rematch) {
+			rematch.Stop()
+			server.Dispatch(Event{
+				Type: "game_start",
+				Payload: &GameStartPayload{
+					Players: rematch.Players,
+					Rules:   rematch.Rules,
+				},
+			})
 		}
+
+	case "rematch_declined":
+		payload := event.Payload.(*protocol.RematchDeclined)
+		rematch, err := g.FindRematch(payload.RematchId)
+
+		if err != nil {
+			event.Reject(protocol.REMATCH_NOT_FOUND, err.Error())
+			return
+		}
+
+		if !Authorize(event, rematch.Players, "rematch", rematch.Id) {
+			return
+		}
+
+		event.Ack()
+		g.CancelRematch(rematch)
+
+	case "secret":
+		payload := event.Payload.(*protocol.Secret)
+		game, err := g.FindGame(payload.GameId)
+
+		if err != nil {
+			event.Reject(protocol.GAME_NOT_FOUND, err.Error())
+			return
+		}
+
+		if !Authorize(event, game.Players, "game", game.Id) {
+			return
+		}
+
+		if err := game.Variant.ValidateSecret(payload.Secret, game.Rules); err != nil {
+			event.Reject(protocol.INVALID_SECRET, err.Error())
+			return
+		}
+
+		if !game.ChooseSecret(event.Socket, payload.Secret) {
+			event.Reject(protocol.INVALID_SECRET, "Secrets can't be chosen anymore")
+			return
+		}
+
+		event.Ack()
 	}
 }
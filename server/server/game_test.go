@@ -1,21 +1,26 @@
 package server
 
 import (
+	"net/http"
 	"testing"
 	"time"
+
+	"example.com/game/client/client"
+	"example.com/game/client/protocol"
+	"github.com/gorilla/websocket"
 )
 
 func TestGame(t *testing.T) {
-	gameManager := NewGameManager()
+	gameManager := NewGameManager(testConfig())
 
-	server := NewServer([]EventHandler{
+	server := NewServer(testConfig(), []EventHandler{
 		gameManager,
-		NewQueueManager(),
-		NewMatchMaker(200 * time.Millisecond),
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
 	})
 
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -25,38 +30,38 @@ func TestGame(t *testing.T) {
 	c1.QueueUp() // wait_for_match
 	c2.QueueUp() // wait_for_match
 
-	c1.GetIncoming() // skips match_found
-	c2.GetIncoming() // skips match_found
+	matchId := c1.WaitForMatch()
+	c2.WaitForMatch()
 
-	c1.AcceptMatch(1) // wait_for_players
-	c2.AcceptMatch(1) // wait_for_players
+	c1.AcceptMatch(matchId) // wait_for_players
+	c2.AcceptMatch(matchId) // wait_for_players
 
 	res := c1.GetIncoming() // guess
 	c2.GetIncoming()        // guess
 
-	gameId := int(res.Payload["GameId"].(float64))
+	gameId := res.Payload.(*protocol.GameStart).GameId
 
 	gameManager.Games[gameId].Answer = 40
 
-	res1 := c1.Guess("69", gameId)
-	res2 := c2.Guess("4", gameId)
+	res1 := c1.Guess(69, gameId)
+	res2 := c2.Guess(4, gameId)
 
 	if res1.Type != "feedback" {
 		t.Errorf("Expected \"feedback\", got \"%v\"", res1.Type)
 	}
-	if res1.Payload["message"] != "Try a smaller number" {
-		t.Errorf("Expected \"Try a smaller number\", got \"%v\"", res1.Payload["message"])
+	if res1.Payload.(*protocol.Feedback).Message != "Try a smaller number" {
+		t.Errorf("Expected \"Try a smaller number\", got \"%v\"", res1.Payload.(*protocol.Feedback).Message)
 	}
 
 	if res2.Type != "feedback" {
 		t.Errorf("Expected \"feedback\", got \"%v\"", res2.Type)
 	}
-	if res2.Payload["message"] != "Try a greater number" {
-		t.Errorf("Expected \"Try a greater number\", got \"%v\"", res2.Payload["message"])
+	if res2.Payload.(*protocol.Feedback).Message != "Try a greater number" {
+		t.Errorf("Expected \"Try a greater number\", got \"%v\"", res2.Payload.(*protocol.Feedback).Message)
 	}
 
-	victory := c1.Guess("40", gameId)
-	defeat := c2.Guess("355", gameId)
+	victory := c1.Guess(40, gameId)
+	defeat := c2.Guess(355, gameId)
 
 	if victory.Type != "victory" {
 		t.Errorf("Expected \"victory\", got \"%v\"", victory.Type)
@@ -68,14 +73,14 @@ func TestGame(t *testing.T) {
 }
 
 func TestDisconnectEndsGame(t *testing.T) {
-	server := NewServer([]EventHandler{
-		NewQueueManager(),
-		NewGameManager(),
-		NewMatchMaker(200 * time.Millisecond),
+	server := NewServer(testConfig(), []EventHandler{
+		NewQueueManager(testConfig()),
+		NewGameManager(testConfig()),
+		NewMatchMaker(testConfig()),
 	})
 
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -85,11 +90,11 @@ func TestDisconnectEndsGame(t *testing.T) {
 	c1.QueueUp() // wait_for_match
 	c2.QueueUp() // wait_for_match
 
-	c1.GetIncoming() // skips match_found
-	c2.GetIncoming() // skips match_found
+	matchId := c1.WaitForMatch()
+	c2.WaitForMatch()
 
-	c1.AcceptMatch(1) // wait_for_players
-	c2.AcceptMatch(1) // wait_for_players
+	c1.AcceptMatch(matchId) // wait_for_players
+	c2.AcceptMatch(matchId) // wait_for_players
 
 	c2.Client.Close()
 
@@ -101,7 +106,868 @@ func TestDisconnectEndsGame(t *testing.T) {
 	if res.Type != "victory" {
 		t.Errorf("Expected \"victory\", got \"%s\"", res.Type)
 	}
-	if res.Payload["message"].(string) != "You won. The other player disconnected." {
-		t.Errorf("Expected other player disconnected message, got \"%s\"", res.Payload["message"].(string))
+	if res.Payload.(*protocol.Victory).Message != "You won. The other player disconnected." {
+		t.Errorf("Expected other player disconnected message, got \"%s\"", res.Payload.(*protocol.Victory).Message)
+	}
+}
+
+// Queues up two clients and confirms their match, returning the game ID
+func startGame(c1 *TestClient, c2 *TestClient) string {
+	c1.QueueUp() // wait_for_match
+	c2.QueueUp() // wait_for_match
+
+	matchId := c1.WaitForMatch()
+	c2.WaitForMatch()
+
+	c1.AcceptMatch(matchId) // wait_for_players
+	c2.AcceptMatch(matchId) // wait_for_players
+
+	res := c1.GetIncoming() // guess
+	c2.GetIncoming()        // guess
+
+	return res.Payload.(*protocol.GameStart).GameId
+}
+
+func TestGuessRepliesWithAckOrError(t *testing.T) {
+	gameManager := NewGameManager(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		gameManager,
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := NewTestClient()
+	other := NewTestClient()
+
+	id := c.Client.Request(client.Message{
+		Type:    "guess",
+		Payload: protocol.Guess{GameId: NewId(), Guess: 1},
+	})
+
+	res := c.GetIncoming()
+	if res.Type != "error" {
+		t.Fatalf("Expected \"error\", got \"%s\"", res.Type)
+	}
+	if res.RequestId != id {
+		t.Errorf("Expected request ID \"%s\", got \"%s\"", id, res.RequestId)
+	}
+	if res.Payload.(*protocol.Error).Code != protocol.GAME_NOT_FOUND {
+		t.Errorf("Expected \"%s\", got \"%s\"", protocol.GAME_NOT_FOUND, res.Payload.(*protocol.Error).Code)
+	}
+
+	gameId := startGame(c, other)
+	gameManager.Games[gameId].Answer = 50
+
+	id = c.Client.Request(client.Message{
+		Type:    "guess",
+		Payload: protocol.Guess{GameId: gameId, Guess: 1},
+	})
+
+	ack := c.GetIncoming()
+	if ack.Type != "ack" {
+		t.Fatalf("Expected \"ack\", got \"%s\"", ack.Type)
+	}
+	if ack.RequestId != id {
+		t.Errorf("Expected request ID \"%s\", got \"%s\"", id, ack.RequestId)
+	}
+}
+
+func TestGuessInAnotherGameIsRejected(t *testing.T) {
+	gameManager := NewGameManager(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		gameManager,
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+	gameId := startGame(c1, c2)
+	gameManager.Games[gameId].Answer = 40
+
+	intruder := NewTestClient()
+	res := intruder.Guess(40, gameId)
+
+	if res.Type != "error" {
+		t.Fatalf("Expected \"error\", got \"%s\"", res.Type)
+	}
+	if res.Payload.(*protocol.Error).Code != protocol.NOT_AUTHORIZED {
+		t.Errorf("Expected \"%s\", got \"%s\"", protocol.NOT_AUTHORIZED, res.Payload.(*protocol.Error).Code)
+	}
+
+	if _, err := gameManager.FindGame(gameId); err != nil {
+		t.Error("Expected game to keep running")
+	}
+
+	if victory := c1.Guess(40, gameId); victory.Type != "victory" {
+		t.Errorf("Expected \"victory\", got \"%s\"", victory.Type)
+	}
+}
+
+func TestShutdownLetsGamesFinish(t *testing.T) {
+	gameManager := NewGameManager(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		gameManager,
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
+	})
+
+	listening := make(chan error)
+	go func() {
+		listening <- server.Listen()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+	c3, _ := NewRawClient("")
+
+	c1.QueueUp() // wait_for_match
+	c2.QueueUp() // wait_for_match
+
+	matchId := c1.WaitForMatch()
+	c2.WaitForMatch()
+
+	c1.AcceptMatch(matchId) // wait_for_players
+	c2.AcceptMatch(matchId) // wait_for_players
+
+	res := c1.GetIncoming() // guess
+	c2.GetIncoming()        // guess
+
+	gameId := res.Payload.(*protocol.GameStart).GameId
+	gameManager.Games[gameId].Answer = 40
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- server.Shutdown(time.Second)
+	}()
+
+	notice := c1.GetIncoming()
+	if notice.Type != "server_shutting_down" {
+		t.Fatalf("Expected \"server_shutting_down\", got \"%s\"", notice.Type)
+	}
+	c2.GetIncoming() // server_shutting_down
+
+	if _, res, err := websocket.DefaultDialer.Dial("ws://0.0.0.0:8080/", nil); err == nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected new connections to be refused, got %v", err)
+	}
+
+	if resumed, session := NewRawClient("?session=" + c3.Token); resumed == nil || !session.Resumed {
+		t.Error("Expected sessions to still be resumable")
+	}
+
+	if victory := c1.Guess(40, gameId); victory.Type != "victory" {
+		t.Errorf("Expected running game to finish, got \"%s\"", victory.Type)
+	}
+	c2.GetIncoming() // loss
+
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("Expected shutdown to not wait for the deadline once games are over")
+	}
+
+	if err := <-listening; err != http.ErrServerClosed {
+		t.Errorf("Expected http.ErrServerClosed, got %v", err)
+	}
+}
+
+func TestShutdownAbortsGamesAfterDeadline(t *testing.T) {
+	server := NewServer(testConfig(), []EventHandler{
+		NewGameManager(testConfig()),
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
+	})
+
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+
+	c1.QueueUp() // wait_for_match
+	c2.QueueUp() // wait_for_match
+
+	matchId := c1.WaitForMatch()
+	c2.WaitForMatch()
+
+	c1.AcceptMatch(matchId) // wait_for_players
+	c2.AcceptMatch(matchId) // wait_for_players
+
+	c1.GetIncoming() // guess
+	c2.GetIncoming() // guess
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- server.Shutdown(100 * time.Millisecond)
+	}()
+
+	c1.GetIncoming() // server_shutting_down
+
+	res := c1.GetIncoming()
+	if res.Type != "game_aborted" {
+		t.Errorf("Expected \"game_aborted\", got \"%s\"", res.Type)
+	}
+
+	<-shutdown
+}
+
+// A socket whose messages are kept instead of written
+func newOfflineSocket() *Socket {
+	socket := NewSocket(&websocket.Conn{})
+	socket.detached = true
+
+	return socket
+}
+
+func lastMessage(socket *Socket) Message {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+
+	if len(socket.pending) == 0 {
+		return Message{}
+	}
+	return socket.pending[len(socket.pending)-1]
+}
+
+// Last message before the standings that end every game
+func lastResult(socket *Socket) Message {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+
+	for i := len(socket.pending) - 1; i >= 0; i-- {
+		if socket.pending[i].Type != "standings" {
+			return socket.pending[i]
+		}
+	}
+	return Message{}
+}
+
+func TestOutOfGuessesAndDraw(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	game := NewGame(NewSockets([]*Socket{p1, p2}), GameRules{Min: 0, Max: 10, MaxGuesses: 2, SharedAnswer: true})
+	game.Answer = 5
+
+	game.CheckGuess(1, p1)
+	if over := game.CheckGuess(2, p1); over {
+		t.Error("Expected game to go on while the other player has guesses")
+	}
+	if res := lastMessage(p1); res.Type != "out_of_guesses" {
+		t.Errorf("Expected \"out_of_guesses\", got \"%s\"", res.Type)
+	}
+
+	game.CheckGuess(3, p1)
+	if game.Guesses[p1] != 2 {
+		t.Errorf("Expected extra guesses to be refused, got %d guesses", game.Guesses[p1])
+	}
+
+	game.CheckGuess(1, p2)
+	if over := game.CheckGuess(2, p2); !over {
+		t.Error("Expected game to be over when everyone is out of guesses")
+	}
+
+	for _, player := range []*Socket{p1, p2} {
+		if res := lastResult(player); res.Type != "draw" {
+			t.Errorf("Expected \"draw\", got \"%s\"", res.Type)
+		}
+	}
+}
+
+func TestOutOfGuessesLosesToWinner(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	game := NewGame(NewSockets([]*Socket{p1, p2}), GameRules{Min: 0, Max: 10, MaxGuesses: 1, SharedAnswer: true})
+	game.Answer = 5
+
+	game.CheckGuess(1, p1)
+
+	if over := game.CheckGuess(5, p2); !over {
+		t.Error("Expected game to be over")
+	}
+	if res := lastResult(p1); res.Type != "loss" {
+		t.Errorf("Expected \"loss\", got \"%s\"", res.Type)
+	}
+	if res := lastResult(p2); res.Type != "victory" {
+		t.Errorf("Expected \"victory\", got \"%s\"", res.Type)
+	}
+}
+
+func TestTimeLimitEndsInDraw(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	game := NewGame(NewSockets([]*Socket{p1, p2}), GameRules{Min: 0, Max: 10, TimeLimit: 20 * time.Millisecond, SharedAnswer: true})
+
+	expired := make(chan bool, 1)
+	game.Start(func() {
+		expired <- true
+	})
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected game to expire")
+	}
+
+	if res := lastResult(p1); res.Type != "draw" {
+		t.Errorf("Expected \"draw\", got \"%s\"", res.Type)
+	}
+	if !game.CheckGuess(game.Answer, p2) || lastResult(p2).Type != "draw" {
+		t.Error("Expected guesses after the time limit to be ignored")
+	}
+}
+
+func TestAbortStopsTimers(t *testing.T) {
+	gameManager := NewGameManager(testConfig())
+
+	game := gameManager.AddGame(GameRules{Min: 0, Max: 10, TimeLimit: 20 * time.Millisecond, SharedAnswer: true}, NewSockets([]*Socket{newOfflineSocket(), newOfflineSocket()}))
+
+	expired := make(chan bool, 1)
+	game.Start(func() {
+		expired <- true
+	})
+
+	gameManager.AbortAll("Game aborted")
+
+	select {
+	case <-expired:
+		t.Error("Expected the timer of an aborted game to be stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPerPlayerAnswers(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	game := NewGame(NewSockets([]*Socket{p1, p2}), GameRules{Min: 0, Max: 10, SharedAnswer: false})
+	game.Answers[p1] = 3
+	game.Answers[p2] = 7
+
+	game.CheckGuess(7, p1)
+	if res := lastMessage(p1); res.Type != "feedback" {
+		t.Errorf("Expected other player's answer to not count, got \"%s\"", res.Type)
+	}
+
+	if !game.CheckGuess(7, p2) {
+		t.Error("Expected own answer to win")
+	}
+}
+
+func TestStandingsAfterVictory(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+	p3 := newOfflineSocket()
+
+	game := NewGame(NewSockets([]*Socket{p1, p2, p3}), GameRules{Min: 0, Max: 10, SharedAnswer: true})
+	game.Answer = 5
+
+	game.CheckGuess(1, p1)
+	if !game.CheckGuess(5, p2) {
+		t.Fatal("Expected first correct guess to end the game")
+	}
+
+	for i, player := range []*Socket{p1, p2, p3} {
+		res := lastMessage(player)
+		if res.Type != "standings" {
+			t.Fatalf("Expected \"standings\", got \"%s\"", res.Type)
+		}
+
+		standings := res.Payload.(protocol.Standings)
+		if standings.You != i+1 {
+			t.Errorf("Expected player %d, got %d", i+1, standings.You)
+		}
+
+		expected := []protocol.Standing{
+			{Player: 2, Place: 1, Guesses: 1, Solved: true},
+			{Player: 1, Place: 2, Guesses: 1, Solved: false},
+			{Player: 3, Place: 2, Guesses: 0, Solved: false},
+		}
+		for j := range expected {
+			if standings.Standings[j] != expected[j] {
+				t.Errorf("Expected %v at %d, got %v", expected[j], j, standings.Standings[j])
+			}
+		}
+	}
+}
+
+func TestKeepPlayingRanksEveryone(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+	p3 := newOfflineSocket()
+
+	game := NewGame(NewSockets([]*Socket{p1, p2, p3}), GameRules{Min: 0, Max: 10, MaxGuesses: 2, SharedAnswer: true, KeepPlaying: true})
+	game.Answer = 5
+
+	if game.CheckGuess(5, p3) {
+		t.Fatal("Expected game to go on after the first correct guess")
+	}
+	if res := lastMessage(p3); res.Type != "finished" || res.Payload.(protocol.Finished).Place != 1 {
+		t.Errorf("Expected to finish 1st, got %v", res)
+	}
+
+	game.CheckGuess(5, p3)
+	if game.Guesses[p3] != 1 {
+		t.Error("Expected guesses after finishing to be ignored")
+	}
+
+	if game.CheckGuess(5, p1) {
+		t.Fatal("Expected game to go on while a player has guesses")
+	}
+
+	game.CheckGuess(1, p2)
+	if !game.CheckGuess(2, p2) {
+		t.Fatal("Expected game to be over once everyone is done")
+	}
+
+	standings := lastMessage(p2).Payload.(protocol.Standings).Standings
+	expected := []protocol.Standing{
+		{Player: 3, Place: 1, Guesses: 1, Solved: true},
+		{Player: 1, Place: 2, Guesses: 1, Solved: true},
+		{Player: 2, Place: 3, Guesses: 2, Solved: false},
+	}
+	for i := range expected {
+		if standings[i] != expected[i] {
+			t.Errorf("Expected %v at %d, got %v", expected[i], i, standings[i])
+		}
+	}
+}
+
+func TestThreePlayerGame(t *testing.T) {
+	config := testConfig()
+	config.PlayersPerMatch = 3
+	gameManager := NewGameManager(config)
+
+	server := NewServer(config, []EventHandler{
+		gameManager,
+		NewQueueManager(config),
+		NewMatchMaker(config),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	clients := []*TestClient{NewTestClient(), NewTestClient(), NewTestClient()}
+
+	for _, c := range clients {
+		c.QueueUp() // wait_for_match
+	}
+
+	matchId := ""
+	for _, c := range clients {
+		matchId = c.WaitForMatch()
+	}
+	for _, c := range clients {
+		c.AcceptMatch(matchId) // wait_for_players
+	}
+
+	players := make(map[int]bool)
+	gameId := ""
+	for _, c := range clients {
+		start := c.GetIncoming().Payload.(*protocol.GameStart)
+		if start.Players != 3 {
+			t.Errorf("Expected 3 players, got %d", start.Players)
+		}
+		players[start.Player] = true
+		gameId = start.GameId
+	}
+	if len(players) != 3 {
+		t.Errorf("Expected each player to get their own number, got %v", players)
+	}
+
+	gameManager.Games[gameId].Answer = 40
+
+	if res := clients[1].Guess(40, gameId); res.Type != "victory" {
+		t.Errorf("Expected \"victory\", got \"%s\"", res.Type)
+	}
+
+	for _, c := range []*TestClient{clients[0], clients[2]} {
+		if res := c.GetIncoming(); res.Type != "loss" {
+			t.Errorf("Expected \"loss\", got \"%s\"", res.Type)
+		}
+	}
+
+	for _, c := range clients {
+		res := c.GetIncoming()
+		if res.Type != "standings" {
+			t.Fatalf("Expected \"standings\", got \"%s\"", res.Type)
+		}
+		if len(res.Payload.(*protocol.Standings).Standings) != 3 {
+			t.Errorf("Expected 3 standings, got %d", len(res.Payload.(*protocol.Standings).Standings))
+		}
+	}
+}
+
+// Messages the socket received of the given type
+func messagesOf(socket *Socket, msgType string) []Message {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+
+	messages := make([]Message, 0)
+	for _, msg := range socket.pending {
+		if msg.Type == msgType {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+func TestTurnBasedRotation(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+	p3 := newOfflineSocket()
+
+	rules := GameRules{Min: 0, Max: 10, SharedAnswer: true, Mode: protocol.TURN_BASED, TurnTime: time.Minute}
+	game := NewGame(NewSockets([]*Socket{p1, p2, p3}), rules)
+	game.Answer = 5
+	game.Start(func() {})
+
+	if res := lastMessage(p2); res.Type != "turn" || res.Payload.(protocol.Turn).Player != 1 {
+		t.Fatalf("Expected player 1's turn, got %v", res)
+	}
+
+	if game.CanGuess(p2) {
+		t.Error("Expected player 2 to wait for their turn")
+	}
+	game.CheckGuess(1, p2)
+	if game.Guesses[p2] != 0 {
+		t.Error("Expected guess out of turn to be ignored")
+	}
+
+	game.CheckGuess(1, p1)
+
+	reveal := messagesOf(p3, "opponent_guess")
+	if len(reveal) != 1 {
+		t.Fatalf("Expected guess to be shown to the other players, got %d", len(reveal))
+	}
+	if guess := reveal[0].Payload.(protocol.OpponentGuess); guess.Player != 1 || guess.Guess != 1 || guess.Message != "Try a greater number" {
+		t.Errorf("Expected player 1's guess of 1, got %v", guess)
+	}
+	if len(messagesOf(p1, "opponent_guess")) != 0 {
+		t.Error("Expected guesser to only get feedback")
+	}
+
+	if !game.CanGuess(p2) {
+		t.Error("Expected turn to pass to player 2")
+	}
+
+	game.CheckGuess(2, p2)
+	game.CheckGuess(3, p3)
+
+	if !game.CanGuess(p1) {
+		t.Error("Expected turn to come back to player 1")
+	}
+}
+
+func TestMissedTurnsForfeit(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	rules := GameRules{Min: 0, Max: 10, SharedAnswer: true, Mode: protocol.TURN_BASED, TurnTime: 20 * time.Millisecond, MaxMissedTurns: 2}
+	game := NewGame(NewSockets([]*Socket{p1, p2}), rules)
+	game.Answer = 5
+
+	expired := make(chan bool, 1)
+	game.Start(func() {
+		expired <- true
+	})
+
+	// player 1 misses their first turn, player 2 guesses in theirs
+	time.Sleep(30 * time.Millisecond)
+	if !game.CanGuess(p2) {
+		t.Fatal("Expected missed turn to be skipped")
+	}
+	game.CheckGuess(1, p2)
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected game to end once player 1 forfeited")
+	}
+
+	missed := messagesOf(p2, "turn_missed")
+	if len(missed) != 2 || !missed[1].Payload.(protocol.TurnMissed).Forfeited {
+		t.Errorf("Expected player 1 to forfeit on the second missed turn, got %v", missed)
+	}
+	if res := lastResult(p2); res.Type != "victory" {
+		t.Errorf("Expected \"victory\", got \"%s\"", res.Type)
+	}
+	if res := lastResult(p1); res.Type != "loss" {
+		t.Errorf("Expected \"loss\", got \"%s\"", res.Type)
+	}
+}
+
+func TestTurnBasedQueue(t *testing.T) {
+	gameManager := NewGameManager(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		gameManager,
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+	c3 := NewTestClient()
+
+	c1.QueueUpFor(TURN_BASED_QUEUE)
+	c2.QueueUpFor(CLASSIC_QUEUE)
+	c3.QueueUpFor(TURN_BASED_QUEUE)
+
+	matchId := c1.WaitForMatch()
+	c3.WaitForMatch()
+
+	c1.AcceptMatch(matchId) // wait_for_players
+	c3.AcceptMatch(matchId) // wait_for_players
+
+	start := c1.GetIncoming().Payload.(*protocol.GameStart)
+	c3.GetIncoming() // guess
+
+	if !start.Rules.TurnBased() {
+		t.Fatalf("Expected turn-based game, got \"%s\"", start.Rules.Mode)
+	}
+
+	c1.GetIncoming() // turn
+	c3.GetIncoming() // turn
+
+	res := c3.Guess(1, start.GameId)
+	if res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.NOT_YOUR_TURN {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.NOT_YOUR_TURN, res)
+	}
+}
+
+func TestDuelSecrets(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	rules := GameRules{Min: 0, Max: 10, Duel: true, SecretTime: time.Minute}
+	game := NewGame(NewSockets([]*Socket{p1, p2}), rules)
+	game.Start(func() {})
+
+	if res := lastMessage(p1); res.Type != "choose_secret" {
+		t.Fatalf("Expected \"choose_secret\", got \"%s\"", res.Type)
+	}
+
+	game.CheckGuess(3, p1)
+	if game.Guesses[p1] != 0 {
+		t.Error("Expected guesses to be ignored while choosing secrets")
+	}
+
+	game.ChooseSecret(p1, 3)
+	game.ChooseSecret(p2, 8)
+
+	if game.ChoosingSecrets() {
+		t.Fatal("Expected guessing to start once everyone chose")
+	}
+	if game.ChooseSecret(p1, 4) {
+		t.Error("Expected secrets to be final")
+	}
+
+	chosen := lastMessage(p1).Payload.(protocol.SecretsChosen)
+	if chosen.Secret != 3 || chosen.Opponent != 2 || chosen.Drawn {
+		t.Errorf("Expected own secret and opponent, got %v", chosen)
+	}
+
+	game.CheckGuess(8, p2)
+	if res := lastMessage(p2); res.Type != "feedback" {
+		t.Errorf("Expected own secret to not count, got \"%s\"", res.Type)
+	}
+	if res := lastMessage(p1); res.Type != "opponent_guess" || res.Payload.(protocol.OpponentGuess).Guess != 8 {
+		t.Errorf("Expected guess to be shown to the owner of the secret, got %v", res)
+	}
+
+	if !game.CheckGuess(8, p1) {
+		t.Error("Expected opponent's secret to win")
+	}
+}
+
+func TestDuelDrawsMissingSecrets(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	rules := GameRules{Min: 0, Max: 10, Duel: true, SecretTime: 20 * time.Millisecond}
+	game := NewGame(NewSockets([]*Socket{p1, p2}), rules)
+	game.Start(func() {})
+
+	game.ChooseSecret(p1, 3)
+	time.Sleep(50 * time.Millisecond)
+
+	if game.ChoosingSecrets() {
+		t.Fatal("Expected selection to end after its time")
+	}
+
+	chosen := messagesOf(p2, "secrets_chosen")
+	if len(chosen) != 1 || !chosen[0].Payload.(protocol.SecretsChosen).Drawn {
+		t.Fatalf("Expected secret of player 2 to be drawn, got %v", chosen)
+	}
+
+	secret := chosen[0].Payload.(protocol.SecretsChosen).Secret
+	if game.AnswerFor(p1) != secret || game.AnswerFor(p2) != 3 {
+		t.Errorf("Expected each player to guess the other's secret, got %d and %d", game.AnswerFor(p1), game.AnswerFor(p2))
+	}
+}
+
+func TestDuelValidatesSecrets(t *testing.T) {
+	config := testConfig()
+	config.Duel = true
+
+	server := NewServer(config, []EventHandler{
+		NewGameManager(config),
+		NewQueueManager(config),
+		NewMatchMaker(config),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+	gameId := startGame(c1, c2)
+
+	c1.GetIncoming() // choose_secret
+	c2.GetIncoming() // choose_secret
+
+	if res := c1.Guess(5, gameId); res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.NOT_STARTED {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.NOT_STARTED, res)
+	}
+
+	if res := c1.ChooseSecret(config.MaxNumber+1, gameId); res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.INVALID_SECRET {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.INVALID_SECRET, res)
+	}
+
+	if res := c1.ChooseSecret(10, gameId); res.Type != "ack" {
+		t.Errorf("Expected \"ack\", got \"%s\"", res.Type)
+	}
+	c2.ChooseSecret(20, gameId) // ack
+
+	res := c1.GetIncoming()
+	if res.Type != "secrets_chosen" || res.Payload.(*protocol.SecretsChosen).Secret != 10 {
+		t.Errorf("Expected own secret to be confirmed, got %v", res)
+	}
+
+	c2.GetIncoming() // secrets_chosen
+
+	if res := c1.Guess(20, gameId); res.Type != "victory" {
+		t.Errorf("Expected \"victory\", got \"%s\"", res.Type)
+	}
+}
+
+func TestLeaveOnlyRewardsRemainingPlayers(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	game := NewGame(NewSockets([]*Socket{p1, p2}), GameRules{Min: 0, Max: 10, SharedAnswer: true})
+
+	if !game.Leave(p2) {
+		t.Fatal("Expected game to be over")
+	}
+	if res := lastResult(p1); res.Type != "victory" {
+		t.Errorf("Expected \"victory\", got \"%s\"", res.Type)
+	}
+	if len(messagesOf(p2, "victory")) != 0 || len(messagesOf(p2, "standings")) != 0 {
+		t.Error("Expected player who left to get no outcome")
+	}
+}
+
+func TestForfeitInThreePlayerGame(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+	p3 := newOfflineSocket()
+
+	game := NewGame(NewSockets([]*Socket{p1, p2, p3}), GameRules{Min: 0, Max: 10, SharedAnswer: true})
+
+	if game.Forfeit(p1) {
+		t.Fatal("Expected game to go on with two players")
+	}
+	if res := lastResult(p1); res.Type != "loss" {
+		t.Errorf("Expected \"loss\", got \"%s\"", res.Type)
+	}
+	if res := lastMessage(p2); res.Type != "player_forfeited" || res.Payload.(protocol.PlayerForfeited).Player != 1 {
+		t.Errorf("Expected player 1 to forfeit, got %v", res)
+	}
+
+	if !game.Forfeit(p3) {
+		t.Fatal("Expected last player standing to win")
+	}
+	if res := lastResult(p2); res.Type != "victory" {
+		t.Errorf("Expected \"victory\", got \"%s\"", res.Type)
+	}
+	if len(messagesOf(p3, "victory")) != 0 {
+		t.Error("Expected player who forfeited to not win")
+	}
+}
+
+func TestDrawOffer(t *testing.T) {
+	gameManager := NewGameManager(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		gameManager,
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+	gameId := startGame(c1, c2)
+
+	c2.Client.Request(client.Message{
+		Type:    "accept_draw",
+		Payload: protocol.GameAction{GameId: gameId},
+	})
+	if res := c2.GetIncoming(); res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.NO_DRAW_OFFER {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.NO_DRAW_OFFER, res)
+	}
+
+	c1.Client.Send(client.Message{
+		Type:    "offer_draw",
+		Payload: protocol.GameAction{GameId: gameId},
+	})
+	if res := c2.GetIncoming(); res.Type != "draw_offered" {
+		t.Fatalf("Expected \"draw_offered\", got \"%s\"", res.Type)
+	}
+
+	c2.Client.Send(client.Message{
+		Type:    "accept_draw",
+		Payload: protocol.GameAction{GameId: gameId},
+	})
+	for _, c := range []*TestClient{c1, c2} {
+		if res := c.GetIncoming(); res.Type != "draw" {
+			t.Errorf("Expected \"draw\", got \"%s\"", res.Type)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := gameManager.FindGame(gameId); err == nil {
+		t.Error("Expected game to be removed")
 	}
 }
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/google/uuid"
+)
+
+// Returns a random UUID for games and matches. IDs are drawn from a
+// cryptographic source, so they neither collide nor can be guessed to
+// act on somebody else's game.
+func NewId() string {
+	return uuid.NewString()
+}
+
+// Returns a secret that resumes a session, longer than an ID since
+// holding it is enough to take over a player's seat
+func NewToken() string {
+	bytes := make([]byte, 32)
+
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(bytes)
+}
+
+// Letters and digits that can't be mistaken for one another
+const JOIN_CODE_ALPHABET = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const JOIN_CODE_LENGTH = 6
+
+// Returns a short code players type in to join a lobby. It's only as hard
+// to guess as it's short, so lobbies live no longer than their players.
+func NewJoinCode() string {
+	bytes := make([]byte, JOIN_CODE_LENGTH)
+
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+
+	for i, b := range bytes {
+		bytes[i] = JOIN_CODE_ALPHABET[int(b)%len(JOIN_CODE_ALPHABET)]
+	}
+	return string(bytes)
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"example.com/game/client/protocol"
+)
+
+const LOBBY_MAX_PLAYERS = 8
+
+// Lobby is a private room players join with its code instead of queueing
+// up. The host picks the rules and starts the game.
+type Lobby struct {
+	Code       string
+	Host       *Socket
+	Players    []*Socket
+	MaxPlayers int
+	Rules      GameRules
+}
+
+func (l *Lobby) remove(socket *Socket) {
+	players := make([]*Socket, 0, len(l.Players))
+
+	for _, player := range l.Players {
+		if player != socket {
+			players = append(players, player)
+		}
+	}
+	l.Players = players
+
+	// whoever joined first takes over
+	if l.Host == socket && len(players) > 0 {
+		l.Host = players[0]
+	}
+}
+
+func (l *Lobby) message() Message {
+	players := make([]string, 0, len(l.Players))
+	for _, player := range l.Players {
+		players = append(players, player.PlayerId())
+	}
+
+	return Message{
+		Type: "lobby",
+		Payload: protocol.Lobby{
+			Code:       l.Code,
+			Host:       l.Host.PlayerId(),
+			Players:    players,
+			MaxPlayers: l.MaxPlayers,
+			Rules:      l.Rules,
+		},
+	}
+}
+
+type LobbyManager struct {
+	mutex   *sync.Mutex
+	lobbies map[string]*Lobby
+	players map[*Socket]*Lobby
+	rules   GameRules
+	busy    BusyCheckers
+
+	draining bool
+}
+
+func NewLobbyManager(config Config) *LobbyManager {
+	return &LobbyManager{
+		mutex:   new(sync.Mutex),
+		lobbies: make(map[string]*Lobby),
+		players: make(map[*Socket]*Lobby),
+		rules:   config.GameRules(),
+	}
+}
+
+// Keeps hosts from starting a game with players the checkers find busy
+func (m *LobbyManager) UseBusy(checkers ...BusyChecker) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.busy = append(m.busy, checkers...)
+}
+
+// Opens a lobby hosted by the socket, which leaves its current one.
+// Returns the lobby it left, if any.
+func (m *LobbyManager) Create(host *Socket, rules GameRules, maxPlayers int) (*Lobby, *Lobby) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	left := m.leave(host)
+
+	code := NewJoinCode()
+	for m.lobbies[code] != nil {
+		code = NewJoinCode()
+	}
+
+	lobby := &Lobby{
+		Code:       code,
+		Host:       host,
+		Players:    []*Socket{host},
+		MaxPlayers: maxPlayers,
+		Rules:      rules,
+	}
+
+	m.lobbies[code] = lobby
+	m.players[host] = lobby
+
+	return lobby, left
+}
+
+// Codes are case insensitive, so they're easy to type in
+func (m *LobbyManager) FindLobby(code string) (*Lobby, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	lobby, ok := m.lobbies[strings.ToUpper(strings.TrimSpace(code))]
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Lobby with code %s not found", code))
+	}
+	return lobby, nil
+}
+
+// Adds the socket to the lobby, leaving its current one. Returns the lobby
+// it left, if any.
+func (m *LobbyManager) Join(socket *Socket, lobby *Lobby) (*Lobby, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.players[socket] == lobby {
+		return nil, nil
+	}
+	if m.lobbies[lobby.Code] != lobby {
+		return nil, protocol.NewError(protocol.LOBBY_NOT_FOUND, "The lobby was closed")
+	}
+	if len(lobby.Players) >= lobby.MaxPlayers {
+		return nil, protocol.NewError(protocol.LOBBY_FULL, fmt.Sprintf("The lobby is full with %d players", lobby.MaxPlayers))
+	}
+
+	left := m.leave(socket)
+
+	lobby.Players = append(lobby.Players, socket)
+	m.players[socket] = lobby
+
+	return left, nil
+}
+
+func (m *LobbyManager) Leave(socket *Socket) *Lobby {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.leave(socket)
+}
+
+// Must hold the mutex
+func (m *LobbyManager) leave(socket *Socket) *Lobby {
+	lobby, ok := m.players[socket]
+	if !ok {
+		return nil
+	}
+
+	lobby.remove(socket)
+	delete(m.players, socket)
+
+	if len(lobby.Players) == 0 {
+		delete(m.lobbies, lobby.Code)
+	}
+	return lobby
+}
+
+// Closes the socket's lobby if it's the host and enough players joined,
+// returning the players and rules of the game to start
+func (m *LobbyManager) Start(event Event) (*Sockets, GameRules, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.draining {
+		event.Reject(protocol.SHUTTING_DOWN, "Server is shutting down")
+		return nil, GameRules{}, false
+	}
+
+	lobby, ok := m.players[event.Socket]
+	if !ok {
+		event.Reject(protocol.LOBBY_NOT_FOUND, "You're not in a lobby")
+		return nil, GameRules{}, false
+	}
+
+	if !Authorize(event, NewSockets([]*Socket{lobby.Host}), "lobby", lobby.Code) {
+		return nil, GameRules{}, false
+	}
+
+	if len(lobby.Players) < 2 {
+		event.Reject(protocol.NOT_ENOUGH_PLAYERS, "Wait for someone to join with the code")
+		return nil, GameRules{}, false
+	}
+
+	// players may have been matched elsewhere while waiting for the host
+	for _, player := range lobby.Players {
+		if m.busy.Busy(player) {
+			event.Reject(protocol.PLAYER_BUSY, fmt.Sprintf("Player %s is already in a match", player.PlayerId()))
+			return nil, GameRules{}, false
+		}
+	}
+
+	for _, player := range lobby.Players {
+		delete(m.players, player)
+	}
+	delete(m.lobbies, lobby.Code)
+
+	return NewSockets(lobby.Players), lobby.Rules, true
+}
+
+// Tells the players of the lobby who's in it now
+func (m *LobbyManager) announce(lobby *Lobby) {
+	if lobby == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	players := NewSockets(append([]*Socket{}, lobby.Players...))
+	msg := lobby.message()
+	m.mutex.Unlock()
+
+	if players.Count() > 0 {
+		players.Send(msg)
+	}
+}
+
+func (m *LobbyManager) Process(event Event, server *Server) {
+	switch event.Type {
+	case "draining":
+		m.mutex.Lock()
+		m.draining = true
+		m.mutex.Unlock()
+
+	case "disconnected":
+		m.announce(m.Leave(event.Socket))
+
+	case "create_lobby":
+		payload := event.Payload.(*protocol.CreateLobby)
+
+		rules := m.rules
+		if payload.Rules != nil {
+			rules = *payload.Rules
+		}
+
+		maxPlayers := payload.MaxPlayers
+		if maxPlayers == 0 || maxPlayers > LOBBY_MAX_PLAYERS {
+			maxPlayers = LOBBY_MAX_PLAYERS
+		}
+
+		lobby, left := m.Create(event.Socket, rules, maxPlayers)
+		event.Ack()
+
+		m.announce(left)
+		m.announce(lobby)
+
+	case "join_lobby":
+		payload := event.Payload.(*protocol.JoinLobby)
+		lobby, err := m.FindLobby(payload.Code)
+
+		if err != nil {
+			event.Reject(protocol.LOBBY_NOT_FOUND, err.Error())
+			return
+		}
+
+		left, err := m.Join(event.Socket, lobby)
+
+		var rejection *protocol.Error
+		if errors.As(err, &rejection) {
+			event.Reject(rejection.Code, rejection.Message)
+			return
+		}
+
+		event.Ack()
+
+		m.announce(left)
+		m.announce(lobby)
+
+	case "leave_lobby":
+		lobby := m.Leave(event.Socket)
+
+		if lobby == nil {
+			event.Reject(protocol.LOBBY_NOT_FOUND, "You're not in a lobby")
+			return
+		}
+
+		event.Ack()
+		event.Socket.Send(Message{
+			Type: "left_lobby",
+			Payload: protocol.LeftLobby{
+				Code: lobby.Code,
+			},
+		})
+		m.announce(lobby)
+
+	case "start_lobby":
+		players, rules, ok := m.Start(event)
+		if !ok {
+			return
+		}
+
+		event.Ack()
+
+		// the same hand-off as a confirmed match
+		server.Dispatch(Event{
+			Type: "game_start",
+			Payload: &GameStartPayload{
+				Players: players,
+				Rules:   rules,
+			},
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/game/client/protocol"
+)
+
+func TestJoinCode(t *testing.T) {
+	code := NewJoinCode()
+
+	if len(code) != JOIN_CODE_LENGTH {
+		t.Errorf("Expected %d characters, got \"%s\"", JOIN_CODE_LENGTH, code)
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(JOIN_CODE_ALPHABET, c) {
+			t.Errorf("Expected only unambiguous characters, got \"%s\"", code)
+		}
+	}
+}
+
+func TestLobbyStartsGame(t *testing.T) {
+	gameManager := NewGameManager(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		gameManager,
+		NewLobbyManager(testConfig()),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	host := NewTestClient()
+	guest := NewTestClient()
+	time.Sleep(10 * time.Millisecond)
+
+	rules := testConfig().GameRules()
+	rules.Max = 10
+	rules.Mode = protocol.TURN_BASED
+
+	host.Client.Send(Message{
+		Type:    "create_lobby",
+		Payload: protocol.CreateLobby{Rules: &rules},
+	})
+	lobby := waitFor(host, "lobby").Payload.(*protocol.Lobby)
+
+	if lobby.Host != host.Client.PlayerId || len(lobby.Players) != 1 {
+		t.Errorf("Expected the host alone in the lobby, got %+v", lobby)
+	}
+
+	host.Client.Send(Message{Type: "start_lobby"})
+	if res := host.GetIncoming(); res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.NOT_ENOUGH_PLAYERS {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.NOT_ENOUGH_PLAYERS, res)
+	}
+
+	guest.Client.Send(Message{
+		Type:    "join_lobby",
+		Payload: protocol.JoinLobby{Code: strings.ToLower(lobby.Code)},
+	})
+
+	lobby = waitFor(guest, "lobby").Payload.(*protocol.Lobby)
+	waitFor(host, "lobby")
+
+	if len(lobby.Players) != 2 {
+		t.Fatalf("Expected 2 players, got %v", lobby.Players)
+	}
+
+	guest.Client.Send(Message{Type: "start_lobby"})
+	if res := guest.GetIncoming(); res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.NOT_AUTHORIZED {
+		t.Errorf("Expected only the host to start, got %v", res)
+	}
+
+	host.Client.Send(Message{Type: "start_lobby"})
+
+	start := waitFor(host, "guess").Payload.(*protocol.GameStart)
+	waitFor(guest, "guess")
+
+	if start.Rules.Max != 10 || !start.Rules.TurnBased() {
+		t.Errorf("Expected the lobby's rules, got %+v", start.Rules)
+	}
+	if start.Players != 2 {
+		t.Errorf("Expected 2 players, got %d", start.Players)
+	}
+
+	game, err := gameManager.FindGame(start.GameId)
+	if err != nil || game.Players.Count() != 2 {
+		t.Errorf("Expected the game to be running, got %v", err)
+	}
+}
+
+func TestLobbyWithBusyPlayerCantStart(t *testing.T) {
+	host := newOfflineSocket()
+	guest := newOfflineSocket()
+
+	lobbies := NewLobbyManager(testConfig())
+	lobbies.UseBusy(FixedBusy{guest})
+
+	lobby, _ := lobbies.Create(host, testConfig().GameRules(), LOBBY_MAX_PLAYERS)
+	lobbies.Join(guest, lobby)
+
+	if _, _, ok := lobbies.Start(Event{Type: "start_lobby", Socket: host}); ok {
+		t.Error("Expected the lobby not to start")
+	}
+
+	res := lastMessage(host)
+	if res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.PLAYER_BUSY {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.PLAYER_BUSY, res)
+	}
+}
+
+func TestLobbyRejectsHugeRange(t *testing.T) {
+	server := NewServer(testConfig(), []EventHandler{
+		NewLobbyManager(testConfig()),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	host := NewTestClient()
+	time.Sleep(10 * time.Millisecond)
+
+	rules := testConfig().GameRules()
+	rules.Min = 0
+	rules.Max = math.MaxInt
+
+	host.Client.Send(Message{
+		Type:    "create_lobby",
+		Payload: protocol.CreateLobby{Rules: &rules},
+	})
+
+	if res := host.GetIncoming(); res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.INVALID_PAYLOAD {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.INVALID_PAYLOAD, res)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+)
+
+type LogLevel int32
+
+const (
+	DEBUG LogLevel = iota
+	INFO
+	WARN
+	ERROR
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+var logLevel = int32(INFO)
+
+// Sets the lowest level that gets logged
+func SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+func logf(level LogLevel, format string, args ...interface{}) {
+	if int32(level) < atomic.LoadInt32(&logLevel) {
+		return
+	}
+	log.Printf("["+levelNames[level]+"] "+format, args...)
+}
+
+func Debugf(format string, args ...interface{}) {
+	logf(DEBUG, format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	logf(INFO, format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	logf(WARN, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	logf(ERROR, format, args...)
+}
+
+func (l *LogLevel) String() string {
+	if l == nil || *l < DEBUG || *l > ERROR {
+		return levelNames[INFO]
+	}
+	return levelNames[*l]
+}
+
+func (l *LogLevel) Set(value string) error {
+	for level, name := range levelNames {
+		if name == value {
+			*l = LogLevel(level)
+			return nil
+		}
+	}
+	return errors.New("expected debug, info, warn or error")
+}
@@ -6,99 +6,33 @@ import (
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"example.com/game/client/protocol"
 )
 
-type Socket struct {
-	conn  *websocket.Conn
-	mutex *sync.Mutex
-}
-
-func NewSocket(conn *websocket.Conn) *Socket {
-	return &Socket{
-		conn:  conn,
-		mutex: new(sync.Mutex),
-	}
-}
-
-func (s *Socket) Send(msg Message) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	s.conn.WriteJSON(msg)
-}
-
-func (s *Socket) Close() {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	s.conn.Close()
-}
-
-type Sockets struct {
-	conns []*Socket
-	mutex *sync.Mutex
-}
-
-func NewSockets(sockets []*Socket) *Sockets {
-	return &Sockets{
-		conns: sockets,
-		mutex: new(sync.Mutex),
-	}
-}
-
-func (s *Sockets) Send(msg Message) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	for _, socket := range s.conns {
-		socket.Send(msg)
-	}
-}
-
-func (s *Sockets) Add(socket *Socket) *Socket {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	s.conns = append(s.conns, socket)
-	return socket
-}
-
-func (s *Sockets) Count() int {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	return len(s.conns)
-}
-
-func (s *Sockets) Has(conn *Socket) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	for _, socket := range s.conns {
-		if socket.conn == conn.conn {
-			return true
-		}
-	}
-	return false
-}
-
 type Match struct {
 	mutex *sync.Mutex
 
-	Id        int
+	Id string
+	// queue the players are put back in if the match is canceled
+	Queue     string
+	Rules     GameRules
 	Players   *Sockets
 	Confirmed *Sockets
 	Ready     chan bool
+
+	// who plays together, so parties are requeued as a whole
+	parties PartyFinder
 }
 
-func NewMatch(id int, players *Sockets) *Match {
+func NewMatch(id string, queue string, rules GameRules, players *Sockets) *Match {
 	return &Match{
 		mutex: new(sync.Mutex),
 
 		Id:        id,
+		Queue:     queue,
+		Rules:     rules,
 		Players:   players,
-		Ready:     make(chan bool),
+		Ready:     make(chan bool, 1),
 		Confirmed: NewSockets([]*Socket{}),
 	}
 }
@@ -109,28 +43,32 @@ func (m *Match) AskForConfirmation() {
 
 	m.Players.Send(Message{
 		Type: "match_found",
-		Payload: map[string]interface{}{
-			"matchId": m.Id,
+		Payload: protocol.MatchFound{
+			MatchId: m.Id,
 		},
 	})
 }
 
-func (m *Match) WaitForConfirmation(timeout time.Duration, dispatch func(event Event)) {
+// Starts the game once everyone confirmed. Returns false if nobody
+// resolved the match in time, leaving it to the caller to cancel it.
+func (m *Match) WaitForConfirmation(timeout time.Duration, dispatch func(event Event)) bool {
 	select {
 	case isReady := <-m.Ready:
 		if isReady {
 			m.mutex.Lock()
 			dispatch(Event{
 				Type: "game_start",
-				Payload: map[string]interface{}{
-					"players": m.Confirmed,
+				Payload: &GameStartPayload{
+					Players: m.Confirmed,
+					Rules:   m.Rules,
 				},
 			})
 			m.mutex.Unlock()
 		}
 	case <-time.After(timeout):
-		m.Cancel(dispatch)
+		return false
 	}
+	return true
 }
 
 func (m *Match) Cancel(dispatch func(event Event)) {
@@ -138,8 +76,8 @@ func (m *Match) Cancel(dispatch func(event Event)) {
 
 	m.Players.Send(Message{
 		Type: "match_canceled",
-		Payload: map[string]interface{}{
-			"match": m.Id,
+		Payload: protocol.MatchCanceled{
+			MatchId: m.Id,
 		},
 	})
 
@@ -147,22 +85,67 @@ func (m *Match) Cancel(dispatch func(event Event)) {
 	m.RequeueConfirmed(dispatch)
 }
 
+// Puts the players who confirmed and are still around back in the queue. A
+// party only goes back, through its leader, if all its members did;
+// otherwise they have to queue up again.
 func (m *Match) RequeueConfirmed(dispatch func(event Event)) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	requeued := make(map[*Socket]bool)
+
 	for _, socket := range m.Confirmed.conns {
+		if socket.Gone() {
+			continue
+		}
+
+		leader, members := socket, []*Socket{socket}
+		if m.parties != nil {
+			if partyLeader, partyMembers := m.parties.PartyOf(socket); partyLeader != nil {
+				leader, members = partyLeader, partyMembers
+			}
+		}
+
+		if requeued[leader] {
+			continue
+		}
+		requeued[leader] = true
+
+		confirmed := true
+		for _, member := range members {
+			confirmed = confirmed && m.Confirmed.Has(member) && !member.Gone()
+		}
+
+		if !confirmed {
+			dequeued(members, nil, "Not all of your party confirmed the match, queue up again")
+			continue
+		}
+
 		dispatch(Event{
-			Type:   "queue_up",
-			Socket: socket,
+			Type:    "queue_up",
+			Socket:  leader,
+			Payload: &protocol.QueueUp{Queue: m.Queue},
 		})
 	}
 }
 
+// Signals WaitForConfirmation without blocking the caller, since it
+// may have already given up on the match
+func (m *Match) Resolve(ready bool) {
+	select {
+	case m.Ready <- ready:
+	default:
+	}
+}
+
+// Counts the socket's confirmation, ignoring it if it already confirmed
 func (m *Match) AddConfirmed(socket *Socket) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if m.Confirmed.Has(socket) {
+		return
+	}
 	m.Confirmed.Add(socket)
 
 	socket.Send(Message{
@@ -170,6 +153,20 @@ func (m *Match) AddConfirmed(socket *Socket) {
 	})
 }
 
+// Takes back the confirmation of a player who declined after all
+func (m *Match) Decline(socket *Socket) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	confirmed := make([]*Socket, 0, len(m.Confirmed.conns))
+	for _, player := range m.Confirmed.conns {
+		if player != socket {
+			confirmed = append(confirmed, player)
+		}
+	}
+	m.Confirmed = NewSockets(confirmed)
+}
+
 func (m *Match) CountConfirmed() int {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -178,28 +175,36 @@ func (m *Match) CountConfirmed() int {
 }
 
 type MatchMaker struct {
-	currentId int
-	timeout   time.Duration
-	matches   map[int]*Match
-	mut       *sync.Mutex
+	timeout time.Duration
+	matches map[string]*Match
+	mut     *sync.Mutex
+	parties PartyFinder
 }
 
-func NewMatchMaker(timeout time.Duration) *MatchMaker {
+func NewMatchMaker(config Config) *MatchMaker {
 	return &MatchMaker{
-		currentId: 0,
-		timeout:   timeout,
-		mut:       new(sync.Mutex),
-		matches:   make(map[int]*Match),
+		timeout: config.ConfirmationTimeout,
+		mut:     new(sync.Mutex),
+		matches: make(map[string]*Match),
 	}
 }
 
-func (m *MatchMaker) AddMatch(players *Sockets) *Match {
+// Lets canceled matches requeue parties as a whole. Without it everyone is
+// requeued alone.
+func (m *MatchMaker) UseParties(parties PartyFinder) {
 	m.mut.Lock()
 	defer m.mut.Unlock()
 
-	m.currentId = m.currentId + 1
-	match := NewMatch(m.currentId, players)
-	m.matches[m.currentId] = match
+	m.parties = parties
+}
+
+func (m *MatchMaker) AddMatch(queue string, rules GameRules, players *Sockets) *Match {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	match := NewMatch(NewId(), queue, rules, players)
+	match.parties = m.parties
+	m.matches[match.Id] = match
 
 	return match
 }
@@ -211,14 +216,14 @@ func (m *MatchMaker) RemoveMatch(match *Match) {
 	delete(m.matches, match.Id)
 }
 
-func (m *MatchMaker) FindMatch(matchId int) (*Match, error) {
+func (m *MatchMaker) FindMatch(matchId string) (*Match, error) {
 	m.mut.Lock()
 	defer m.mut.Unlock()
 
 	match, ok := m.matches[matchId]
 
 	if !ok {
-		return nil, errors.New(fmt.Sprintf("Match with ID %d not found", matchId))
+		return nil, errors.New(fmt.Sprintf("Match with ID %s not found", matchId))
 	}
 
 	return match, nil
@@ -236,6 +241,11 @@ func (m *MatchMaker) FindMatchWithSocket(socket *Socket) *Match {
 	return nil
 }
 
+// Whether the socket is in a match waiting for confirmation
+func (m *MatchMaker) Busy(socket *Socket) bool {
+	return m.FindMatchWithSocket(socket) != nil
+}
+
 // Returns the current number of matches pending
 func (m *MatchMaker) Count() int {
 	m.mut.Lock()
@@ -244,45 +254,89 @@ func (m *MatchMaker) Count() int {
 	return len(m.matches)
 }
 
+// Cancels every pending match
+func (m *MatchMaker) CancelAll(dispatch func(event Event)) {
+	m.mut.Lock()
+	matches := m.matches
+	m.matches = make(map[string]*Match)
+	m.mut.Unlock()
+
+	for _, match := range matches {
+		match.Cancel(dispatch)
+		match.Resolve(false)
+	}
+}
+
+// Pending matches a graceful shutdown waits for
+func (m *MatchMaker) Pending() int {
+	return m.Count()
+}
+
 func (m *MatchMaker) Process(event Event, server *Server) {
 	switch event.Type {
+	case "shutdown":
+		m.CancelAll(server.Dispatch)
+
 	case "disconnected":
 		match := m.FindMatchWithSocket(event.Socket)
 
 		if match != nil {
 			m.RemoveMatch(match)
 			match.Cancel(server.Dispatch)
-			match.Ready <- false
+			match.Resolve(false)
 		}
 
 	case "match_found":
-		players := event.Payload["players"].([]*Socket)
-		match := m.AddMatch(NewSockets(players))
+		payload := event.Payload.(*MatchFoundPayload)
+		match := m.AddMatch(payload.Queue, payload.Rules, NewSockets(payload.Players))
 
 		match.AskForConfirmation()
-		go match.WaitForConfirmation(m.timeout, server.Dispatch)
+		go func() {
+			if !match.WaitForConfirmation(m.timeout, server.Dispatch) {
+				// removed first, so the requeued players aren't busy anymore
+				m.RemoveMatch(match)
+				match.Cancel(server.Dispatch)
+			}
+		}()
 
 	case "match_confirmed":
-		matchId := int(event.Payload["matchId"].(float64))
-		match, err := m.FindMatch(matchId)
+		payload := event.Payload.(*protocol.MatchConfirmed)
+		match, err := m.FindMatch(payload.MatchId)
+
+		if err != nil {
+			event.Reject(protocol.MATCH_NOT_FOUND, err.Error())
+			return
+		}
 
-		if err == nil {
-			match.AddConfirmed(event.Socket)
+		if !Authorize(event, match.Players, "match", match.Id) {
+			return
+		}
 
-			if match.CountConfirmed() == NUM_OF_PLAYERS {
-				match.Ready <- true
-				m.RemoveMatch(match)
-			}
+		event.Ack()
+		match.AddConfirmed(event.Socket)
+
+		if match.CountConfirmed() == match.Players.Count() {
+			match.Resolve(true)
+			m.RemoveMatch(match)
 		}
 
 	case "match_declined":
-		matchId := int(event.Payload["matchId"].(float64))
-		match, err := m.FindMatch(matchId)
+		payload := event.Payload.(*protocol.MatchDeclined)
+		match, err := m.FindMatch(payload.MatchId)
 
-		if err == nil {
-			m.RemoveMatch(match)
-			match.Cancel(server.Dispatch)
-			match.Ready <- false
+		if err != nil {
+			event.Reject(protocol.MATCH_NOT_FOUND, err.Error())
+			return
 		}
+
+		if !Authorize(event, match.Players, "match", match.Id) {
+			return
+		}
+
+		event.Ack()
+		m.RemoveMatch(match)
+		match.Decline(event.Socket)
+		match.Cancel(server.Dispatch)
+		match.Resolve(false)
 	}
 }
@@ -3,16 +3,18 @@ package server
 import (
 	"testing"
 	"time"
+
+	"example.com/game/client/protocol"
 )
 
 func TestMatchFound(t *testing.T) {
-	server := NewServer([]EventHandler{
-		NewQueueManager(),
-		NewMatchMaker(100 * time.Millisecond),
+	server := NewServer(testConfig(), []EventHandler{
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
 	})
 
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
@@ -27,8 +29,9 @@ func TestMatchFound(t *testing.T) {
 		t.Errorf("Expected match_found, got %s", res1.Type)
 	}
 
-	if int(res1.Payload["matchId"].(float64)) != 1 {
-		t.Errorf("Expected matchId: 1, got %d", int(res1.Payload["matchId"].(float64)))
+	matchId := res1.Payload.(*protocol.MatchFound).MatchId
+	if matchId == "" {
+		t.Error("Expected matchId")
 	}
 
 	res2 := c2.GetIncoming()
@@ -36,21 +39,21 @@ func TestMatchFound(t *testing.T) {
 		t.Errorf("Expected match_found response, got %s", res2.Type)
 	}
 
-	if int(res2.Payload["matchId"].(float64)) != 1 {
-		t.Errorf("Expected matchId: 1, got %d", int(res2.Payload["matchId"].(float64)))
+	if res2.Payload.(*protocol.MatchFound).MatchId != matchId {
+		t.Errorf("Expected matchId: %s, got %s", matchId, res2.Payload.(*protocol.MatchFound).MatchId)
 	}
 }
 
 func TestConfirmsMatch(t *testing.T) {
-	matchMaker := NewMatchMaker(100 * time.Millisecond)
-	server := NewServer([]EventHandler{
-		NewQueueManager(),
-		NewGameManager(),
+	matchMaker := NewMatchMaker(testConfig())
+	server := NewServer(testConfig(), []EventHandler{
+		NewQueueManager(testConfig()),
+		NewGameManager(testConfig()),
 		matchMaker,
 	})
 
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
@@ -60,27 +63,27 @@ func TestConfirmsMatch(t *testing.T) {
 	c1.QueueUp() // wait_for_match
 	c2.QueueUp() // wait_for_match
 
-	c1.GetIncoming() // skips match_found
-	c2.GetIncoming() // skips match_found
+	matchId := c1.WaitForMatch()
+	c2.WaitForMatch()
 
-	wait1 := c1.AcceptMatch(1)
+	wait1 := c1.AcceptMatch(matchId)
 	if wait1.Type != "wait_for_players" {
 		t.Errorf("Expected \"wait_for_players\", got \"%s\"", wait1.Type)
 	}
 
-	match, _ := matchMaker.FindMatch(1)
+	match, _ := matchMaker.FindMatch(matchId)
 
 	if match.Confirmed.Count() != 1 {
 		t.Errorf("Expected 1 confirmed, got %d", match.Confirmed.Count())
 	}
 
-	wait2 := c2.AcceptMatch(1)
+	wait2 := c2.AcceptMatch(matchId)
 
 	if wait2.Type != "wait_for_players" {
 		t.Errorf("Expected \"wait_for_players\", got \"%s\"", wait2.Type)
 	}
 
-	if match, _ := matchMaker.FindMatch(1); match != nil {
+	if match, _ := matchMaker.FindMatch(matchId); match != nil {
 		t.Errorf("Expected match to be resolved %v", matchMaker.Count())
 	}
 
@@ -95,16 +98,16 @@ func TestConfirmsMatch(t *testing.T) {
 }
 
 func TestDenyMatch(t *testing.T) {
-	matchMaker := NewMatchMaker(100 * time.Millisecond)
-	queueManager := NewQueueManager()
+	matchMaker := NewMatchMaker(testConfig())
+	queueManager := NewQueueManager(testConfig())
 
-	server := NewServer([]EventHandler{
+	server := NewServer(testConfig(), []EventHandler{
 		queueManager,
 		matchMaker,
 	})
 
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
@@ -114,8 +117,11 @@ func TestDenyMatch(t *testing.T) {
 	c1.QueueUp()
 	c2.QueueUp()
 
-	c1.AcceptMatch(1)
-	c2.DenyMatch(1)
+	matchId := c1.WaitForMatch()
+	c2.WaitForMatch()
+
+	c1.AcceptMatch(matchId)
+	c2.DenyMatch(matchId)
 
 	time.Sleep(time.Millisecond)
 
@@ -124,17 +130,59 @@ func TestDenyMatch(t *testing.T) {
 	}
 }
 
+func TestOutsiderCannotAnswerMatch(t *testing.T) {
+	matchMaker := NewMatchMaker(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		NewQueueManager(testConfig()),
+		matchMaker,
+	})
+
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+
+	c1.QueueUp()
+	c2.QueueUp()
+
+	matchId := c1.WaitForMatch()
+	c2.WaitForMatch()
+
+	intruder := NewTestClient()
+
+	for _, res := range []protocol.Message{intruder.AcceptMatch(matchId), intruder.DenyMatch(matchId)} {
+		if res.Type != "error" {
+			t.Fatalf("Expected error, got %s", res.Type)
+		}
+		if res.Payload.(*protocol.Error).Code != protocol.NOT_AUTHORIZED {
+			t.Errorf("Expected %s, got %s", protocol.NOT_AUTHORIZED, res.Payload.(*protocol.Error).Code)
+		}
+	}
+
+	match, err := matchMaker.FindMatch(matchId)
+	if err != nil {
+		t.Fatal("Expected match to be kept")
+	}
+	if match.CountConfirmed() != 0 {
+		t.Errorf("Expected no confirmations, got %d", match.CountConfirmed())
+	}
+}
+
 func TestTimeout(t *testing.T) {
-	matchMaker := NewMatchMaker(100 * time.Millisecond)
-	queueManager := NewQueueManager()
+	matchMaker := NewMatchMaker(testConfig())
+	queueManager := NewQueueManager(testConfig())
 
-	server := NewServer([]EventHandler{
+	server := NewServer(testConfig(), []EventHandler{
 		queueManager,
 		matchMaker,
 	})
 
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
@@ -144,10 +192,9 @@ func TestTimeout(t *testing.T) {
 	c1.QueueUp() // wait_for_match
 	c2.QueueUp() // wait_for_match
 
-	c1.AcceptMatch(1) // match found
+	c1.AcceptMatch(c1.WaitForMatch()) // wait_for_players
 
-	c1.GetIncoming() // wait_for_players
-	c1.GetIncoming() // match_declined
+	c1.GetIncoming() // match_canceled
 
 	time.Sleep(time.Millisecond)
 
@@ -158,14 +205,14 @@ func TestTimeout(t *testing.T) {
 }
 
 func TestDisconnectCancelsMatch(t *testing.T) {
-	matchMaker := NewMatchMaker(100 * time.Millisecond)
+	matchMaker := NewMatchMaker(testConfig())
 
-	server := NewServer([]EventHandler{
-		NewQueueManager(),
+	server := NewServer(testConfig(), []EventHandler{
+		NewQueueManager(testConfig()),
 		matchMaker,
 	})
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
@@ -175,7 +222,7 @@ func TestDisconnectCancelsMatch(t *testing.T) {
 	c1.QueueUp() // wait for match
 	c2.QueueUp() // wait for match
 
-	c1.AcceptMatch(1) // match found
+	c1.AcceptMatch(c1.WaitForMatch()) // wait_for_players
 	c1.Client.Close()
 
 	time.Sleep(time.Millisecond)
@@ -192,14 +239,14 @@ func TestDisconnectCancelsMatch(t *testing.T) {
 }
 
 func TestDisconnectRequeuesConfirmed(t *testing.T) {
-	matchMaker := NewMatchMaker(100 * time.Millisecond)
+	matchMaker := NewMatchMaker(testConfig())
 
-	server := NewServer([]EventHandler{
-		NewQueueManager(),
+	server := NewServer(testConfig(), []EventHandler{
+		NewQueueManager(testConfig()),
 		matchMaker,
 	})
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
@@ -209,12 +256,11 @@ func TestDisconnectRequeuesConfirmed(t *testing.T) {
 	c1.QueueUp() // wait for match
 	c2.QueueUp() // wait for match
 
-	c1.AcceptMatch(1) // match found
+	c1.AcceptMatch(c1.WaitForMatch()) // wait_for_players
 	c2.Client.Close()
 
 	time.Sleep(time.Millisecond)
 
-	c1.GetIncoming() // wait_for_players
 	c1.GetIncoming() // match_canceled
 
 	res := c1.GetIncoming() // wait_for_match
@@ -226,3 +272,48 @@ func TestDisconnectRequeuesConfirmed(t *testing.T) {
 		t.Errorf("Expected matches to have count 0, got %d", len(matchMaker.matches))
 	}
 }
+
+func TestConfirmingTwiceCountsOnce(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	match := NewMatch(NewId(), CLASSIC_QUEUE, GameRules{}, NewSockets([]*Socket{p1, p2}))
+
+	match.AddConfirmed(p1)
+	match.AddConfirmed(p1)
+
+	if match.CountConfirmed() != 1 {
+		t.Errorf("Expected 1 confirmed, got %d", match.CountConfirmed())
+	}
+}
+
+func TestLeavingAfterConfirmingIsNotRequeued(t *testing.T) {
+	queueManager := NewQueueManager(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		queueManager,
+		NewMatchMaker(testConfig()),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+
+	c1.QueueUp() // wait_for_match
+	c2.QueueUp() // wait_for_match
+
+	c1.AcceptMatch(c1.WaitForMatch()) // wait_for_players
+	c2.WaitForMatch()
+
+	c1.Client.Close()
+	c2.GetIncoming() // match_canceled
+
+	time.Sleep(50 * time.Millisecond)
+
+	if queueManager.Count() != 0 {
+		t.Errorf("Expected nobody to be requeued, got %d waiting", queueManager.Count())
+	}
+}
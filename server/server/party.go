@@ -0,0 +1,363 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"example.com/game/client/protocol"
+)
+
+// Party is a group of players who queue up together. Only the leader may
+// invite, kick, promote and queue up for the party.
+type Party struct {
+	Id      string
+	Leader  *Socket
+	Members []*Socket
+	Invited map[*Socket]bool
+}
+
+func NewParty(leader *Socket) *Party {
+	return &Party{
+		Id:      NewId(),
+		Leader:  leader,
+		Members: []*Socket{leader},
+		Invited: make(map[*Socket]bool),
+	}
+}
+
+func (p *Party) remove(socket *Socket) {
+	members := make([]*Socket, 0, len(p.Members))
+
+	for _, member := range p.Members {
+		if member != socket {
+			members = append(members, member)
+		}
+	}
+	p.Members = members
+
+	// the longest standing member takes over
+	if p.Leader == socket && len(members) > 0 {
+		p.Leader = members[0]
+	}
+}
+
+func (p *Party) message() Message {
+	members := make([]string, 0, len(p.Members))
+	for _, member := range p.Members {
+		members = append(members, member.PlayerId())
+	}
+
+	return Message{
+		Type: "party",
+		Payload: protocol.Party{
+			PartyId: p.Id,
+			Leader:  p.Leader.PlayerId(),
+			Members: members,
+		},
+	}
+}
+
+// PartyFinder is what the queue learns who plays together from
+type PartyFinder interface {
+	// Leader and members of the socket's party, nil if it's in none
+	PartyOf(socket *Socket) (*Socket, []*Socket)
+}
+
+type PartyManager struct {
+	mutex   *sync.Mutex
+	parties map[string]*Party
+	members map[*Socket]*Party
+}
+
+func NewPartyManager() *PartyManager {
+	return &PartyManager{
+		mutex:   new(sync.Mutex),
+		parties: make(map[string]*Party),
+		members: make(map[*Socket]*Party),
+	}
+}
+
+func (m *PartyManager) PartyOf(socket *Socket) (*Socket, []*Socket) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	party, ok := m.members[socket]
+	if !ok {
+		return nil, nil
+	}
+
+	return party.Leader, append([]*Socket{}, party.Members...)
+}
+
+func (m *PartyManager) FindParty(id string) (*Party, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	party, ok := m.parties[id]
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Party with ID %s not found", id))
+	}
+	return party, nil
+}
+
+// Starts a party led by the socket, which leaves its current one
+func (m *PartyManager) Create(socket *Socket) (*Party, *Party) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	left := m.leave(socket)
+
+	party := NewParty(socket)
+	m.parties[party.Id] = party
+	m.members[socket] = party
+
+	return party, left
+}
+
+// Adds the socket to the party, leaving its current one. Returns the party
+// it left, if any, and false if the party broke up in the meantime.
+func (m *PartyManager) Join(socket *Socket, party *Party) (*Party, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.parties[party.Id] != party {
+		return nil, false
+	}
+
+	left := m.leave(socket)
+
+	delete(party.Invited, socket)
+	party.Members = append(party.Members, socket)
+	m.members[socket] = party
+
+	return left, true
+}
+
+// Takes the socket out of its party, returning the party it left
+func (m *PartyManager) Leave(socket *Socket) *Party {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.leave(socket)
+}
+
+// Must hold the mutex
+func (m *PartyManager) leave(socket *Socket) *Party {
+	party, ok := m.members[socket]
+	if !ok {
+		return nil
+	}
+
+	party.remove(socket)
+	delete(m.members, socket)
+
+	if len(party.Members) == 0 {
+		delete(m.parties, party.Id)
+	}
+	return party
+}
+
+// Runs f with the party locked, returning the message that tells its
+// members about the change
+func (m *PartyManager) update(party *Party, f func()) Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	f()
+	return party.message()
+}
+
+func (m *PartyManager) snapshot(party *Party) (*Sockets, *Sockets, Message) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	invited := make([]*Socket, 0, len(party.Invited))
+	for socket := range party.Invited {
+		invited = append(invited, socket)
+	}
+
+	return NewSockets(append([]*Socket{}, party.Members...)), NewSockets(invited), party.message()
+}
+
+// Tells the members of a party who's in it now, and the queue that the
+// party changed along with the player who joined or left it
+func (m *PartyManager) announce(party *Party, player *Socket, server *Server) {
+	if party == nil {
+		return
+	}
+
+	members, _, msg := m.snapshot(party)
+	if members.Count() > 0 {
+		members.Send(msg)
+	}
+
+	server.Dispatch(Event{
+		Type: "party_changed",
+		Payload: &PartyChangedPayload{
+			Members: append(members.conns, player),
+		},
+	})
+}
+
+// Finds the socket's party and checks it's the leader
+func (m *PartyManager) led(event Event) (*Party, bool) {
+	m.mutex.Lock()
+	party, ok := m.members[event.Socket]
+	var leader *Socket
+	if ok {
+		leader = party.Leader
+	}
+	m.mutex.Unlock()
+
+	if !ok {
+		event.Reject(protocol.PARTY_NOT_FOUND, "You're not in a party")
+		return nil, false
+	}
+
+	if !Authorize(event, NewSockets([]*Socket{leader}), "party", party.Id) {
+		return nil, false
+	}
+	return party, true
+}
+
+// Finds the member of the party the action names
+func (m *PartyManager) member(event Event, party *Party) (*Socket, bool) {
+	payload := event.Payload.(*protocol.PartyAction)
+
+	members, _, _ := m.snapshot(party)
+	for _, member := range members.conns {
+		if member.PlayerId() == payload.PlayerId {
+			return member, true
+		}
+	}
+
+	event.Reject(protocol.PLAYER_NOT_FOUND, fmt.Sprintf("Player %s isn't in your party", payload.PlayerId))
+	return nil, false
+}
+
+func (m *PartyManager) Process(event Event, server *Server) {
+	switch event.Type {
+	case "disconnected":
+		m.announce(m.Leave(event.Socket), event.Socket, server)
+
+	case "create_party":
+		party, left := m.Create(event.Socket)
+		event.Ack()
+
+		m.announce(left, event.Socket, server)
+		event.Socket.Send(party.message())
+
+	case "invite_player":
+		party, ok := m.led(event)
+		if !ok {
+			return
+		}
+
+		payload := event.Payload.(*protocol.PartyAction)
+		player := server.FindPlayer(payload.PlayerId)
+
+		if player == nil || player == event.Socket {
+			event.Reject(protocol.PLAYER_NOT_FOUND, fmt.Sprintf("Player %s not found", payload.PlayerId))
+			return
+		}
+
+		m.update(party, func() {
+			party.Invited[player] = true
+		})
+		event.Ack()
+
+		player.Send(Message{
+			Type: "party_invite",
+			Payload: protocol.PartyInvite{
+				PartyId: party.Id,
+				From:    event.Socket.PlayerId(),
+			},
+		})
+
+	case "join_party":
+		payload := event.Payload.(*protocol.JoinParty)
+		party, err := m.FindParty(payload.PartyId)
+
+		if err != nil {
+			event.Reject(protocol.PARTY_NOT_FOUND, err.Error())
+			return
+		}
+
+		_, invited, _ := m.snapshot(party)
+		if !Authorize(event, invited, "party", party.Id) {
+			return
+		}
+
+		left, ok := m.Join(event.Socket, party)
+		if !ok {
+			event.Reject(protocol.PARTY_NOT_FOUND, "The party broke up")
+			return
+		}
+
+		event.Ack()
+
+		m.announce(left, event.Socket, server)
+		m.announce(party, event.Socket, server)
+
+	case "leave_party":
+		party := m.Leave(event.Socket)
+
+		if party == nil {
+			event.Reject(protocol.PARTY_NOT_FOUND, "You're not in a party")
+			return
+		}
+
+		event.Ack()
+		event.Socket.Send(Message{
+			Type: "left_party",
+			Payload: protocol.LeftParty{
+				PartyId: party.Id,
+			},
+		})
+		m.announce(party, event.Socket, server)
+
+	case "kick_player":
+		party, ok := m.led(event)
+		if !ok {
+			return
+		}
+
+		player, ok := m.member(event, party)
+		if !ok {
+			return
+		}
+
+		event.Ack()
+		m.Leave(player)
+
+		player.Send(Message{
+			Type: "left_party",
+			Payload: protocol.LeftParty{
+				PartyId: party.Id,
+				Kicked:  true,
+			},
+		})
+		m.announce(party, player, server)
+
+	case "promote_player":
+		party, ok := m.led(event)
+		if !ok {
+			return
+		}
+
+		player, ok := m.member(event, party)
+		if !ok {
+			return
+		}
+
+		event.Ack()
+		msg := m.update(party, func() {
+			party.Leader = player
+		})
+
+		members, _, _ := m.snapshot(party)
+		members.Send(msg)
+	}
+}
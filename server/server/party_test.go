@@ -0,0 +1,231 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/game/client/client"
+	"example.com/game/client/protocol"
+)
+
+type FixedParty []*Socket
+
+func (p FixedParty) PartyOf(socket *Socket) (*Socket, []*Socket) {
+	for _, member := range p {
+		if member == socket {
+			return p[0], p
+		}
+	}
+	return nil, nil
+}
+
+// Reads messages until one of the given type arrives
+func waitFor(c *TestClient, msgType string) client.Message {
+	for {
+		if msg := c.GetIncoming(); msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+func TestPartyQueuesAsAUnit(t *testing.T) {
+	leader := newOfflineSocket()
+	member := newOfflineSocket()
+	solo := newOfflineSocket()
+	other := newOfflineSocket()
+
+	queues := NewQueueManager(testConfig())
+	queues.UseParties(FixedParty{leader, member})
+
+	if _, _, err := queues.Enqueue(member, ""); err == nil {
+		t.Error("Expected only the leader to queue up")
+	}
+
+	queues.Enqueue(solo, FFA_QUEUE)
+	queues.Enqueue(leader, FFA_QUEUE)
+
+	if queues.Count() != 3 {
+		t.Errorf("Expected the whole party to queue up, got %d waiting", queues.Count())
+	}
+
+	if removed := queues.Remove(member); len(removed) != 2 {
+		t.Errorf("Expected a member to dequeue the whole party, got %v", removed)
+	}
+
+	queues.Enqueue(leader, FFA_QUEUE)
+	_, players, _ := queues.Enqueue(other, FFA_QUEUE)
+
+	if len(players) != 4 {
+		t.Fatalf("Expected a match of four, got %v", players)
+	}
+	if players[0] != solo || players[1] != leader || players[2] != member {
+		t.Errorf("Expected the party to be matched together, got %v", players)
+	}
+}
+
+func TestPartyTooBig(t *testing.T) {
+	party := FixedParty{newOfflineSocket(), newOfflineSocket(), newOfflineSocket()}
+
+	queues := NewQueueManager(testConfig())
+	queues.UseParties(party)
+
+	_, _, err := queues.Enqueue(party[0], CLASSIC_QUEUE)
+	if rejection, ok := err.(*protocol.Error); !ok || rejection.Code != protocol.PARTY_TOO_BIG {
+		t.Errorf("Expected \"%s\", got %v", protocol.PARTY_TOO_BIG, err)
+	}
+}
+
+func TestPartyDisconnectDequeuesParty(t *testing.T) {
+	parties := NewPartyManager()
+	queues := NewQueueManager(testConfig())
+	queues.UseParties(parties)
+
+	server := NewServer(testConfig(), []EventHandler{
+		queues,
+		parties,
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+	time.Sleep(10 * time.Millisecond)
+
+	c1.Client.Send(Message{Type: "create_party"})
+	party := waitFor(c1, "party").Payload.(*protocol.Party)
+
+	if party.Leader != c1.Client.PlayerId {
+		t.Errorf("Expected the creator to lead the party, got %s", party.Leader)
+	}
+
+	c2.Client.Send(Message{
+		Type:    "join_party",
+		Payload: protocol.JoinParty{PartyId: party.PartyId},
+	})
+	if res := c2.GetIncoming(); res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.NOT_AUTHORIZED {
+		t.Errorf("Expected players to need an invite, got %v", res)
+	}
+
+	c1.Client.Send(Message{
+		Type:    "invite_player",
+		Payload: protocol.PartyAction{PlayerId: c2.Client.PlayerId},
+	})
+	invite := waitFor(c2, "party_invite").Payload.(*protocol.PartyInvite)
+
+	c2.Client.Send(Message{
+		Type:    "join_party",
+		Payload: protocol.JoinParty{PartyId: invite.PartyId},
+	})
+
+	party = waitFor(c1, "party").Payload.(*protocol.Party)
+	waitFor(c2, "party")
+
+	if len(party.Members) != 2 {
+		t.Fatalf("Expected 2 members, got %v", party.Members)
+	}
+
+	c1.QueueUpFor(FFA_QUEUE)
+	waitFor(c2, "wait_for_match")
+
+	if queues.Count() != 2 {
+		t.Errorf("Expected the party to queue up, got %d waiting", queues.Count())
+	}
+
+	c2.Client.Close()
+
+	waitFor(c1, "dequeued")
+	party = waitFor(c1, "party").Payload.(*protocol.Party)
+
+	if queues.Count() != 0 {
+		t.Errorf("Expected the party to be dequeued, got %d waiting", queues.Count())
+	}
+	if len(party.Members) != 1 {
+		t.Errorf("Expected the party to lose the member, got %v", party.Members)
+	}
+}
+
+func TestCanceledMatchRequeuesWholeParties(t *testing.T) {
+	leader := newOfflineSocket()
+	member := newOfflineSocket()
+	solo := newOfflineSocket()
+	other := newOfflineSocket()
+
+	match := NewMatch(NewId(), FFA_QUEUE, GameRules{}, NewSockets([]*Socket{leader, member, solo, other}))
+	match.parties = FixedParty{leader, member}
+
+	requeued := func() []*Socket {
+		sockets := []*Socket{}
+		match.RequeueConfirmed(func(event Event) {
+			sockets = append(sockets, event.Socket)
+		})
+		return sockets
+	}
+
+	match.AddConfirmed(member)
+	match.AddConfirmed(solo)
+
+	if sockets := requeued(); len(sockets) != 1 || sockets[0] != solo {
+		t.Errorf("Expected only the solo player to be requeued, got %v", sockets)
+	}
+	for _, player := range []*Socket{leader, member} {
+		if msg := lastMessage(player); msg.Type != "dequeued" {
+			t.Errorf("Expected the party to be dequeued, got \"%s\"", msg.Type)
+		}
+	}
+
+	match.AddConfirmed(leader)
+
+	if sockets := requeued(); len(sockets) != 2 || sockets[0] != leader {
+		t.Errorf("Expected the party to be requeued once by its leader, got %v", sockets)
+	}
+}
+
+type FixedBusy []*Socket
+
+func (b FixedBusy) Busy(socket *Socket) bool {
+	for _, busy := range b {
+		if busy == socket {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBusyPlayerCantQueue(t *testing.T) {
+	player := newOfflineSocket()
+
+	queues := NewQueueManager(testConfig())
+	queues.UseBusy(FixedBusy{player})
+
+	_, _, err := queues.Enqueue(player, FFA_QUEUE)
+
+	var rejection *protocol.Error
+	if !errors.As(err, &rejection) || rejection.Code != protocol.PLAYER_BUSY {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.PLAYER_BUSY, err)
+	}
+	if queues.Count() != 0 {
+		t.Errorf("Expected nobody to queue up, got %d waiting", queues.Count())
+	}
+}
+
+func TestPartyWithBusyMemberCantQueue(t *testing.T) {
+	leader := newOfflineSocket()
+	member := newOfflineSocket()
+
+	queues := NewQueueManager(testConfig())
+	queues.UseParties(FixedParty{leader, member})
+	queues.UseBusy(FixedBusy{member})
+
+	_, _, err := queues.Enqueue(leader, FFA_QUEUE)
+
+	var rejection *protocol.Error
+	if !errors.As(err, &rejection) || rejection.Code != protocol.PLAYER_BUSY {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.PLAYER_BUSY, err)
+	}
+	if queues.Count() != 0 {
+		t.Errorf("Expected nobody to queue up, got %d waiting", queues.Count())
+	}
+}
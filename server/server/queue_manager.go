@@ -1,17 +1,36 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"sync"
+	"time"
+
+	"example.com/game/client/protocol"
 )
 
-const NUM_OF_PLAYERS = 2
+// how far back the matches estimating the wait in a queue go
+const THROUGHPUT_WINDOW = 10 * time.Minute
 
 type Queue struct {
 	Head *Node
 	Tail *Node
 
-	mut     *sync.Mutex
-	sockets map[*Socket]*Node
+	// name, player count, rules and matchmaking of the queue
+	Settings QueueSettings
+
+	mut      *sync.Mutex
+	sockets  map[*Socket]*Node
+	strategy Strategy
+
+	// when the queue opened, and the players it matched recently
+	opened  time.Time
+	matched []Matched
+}
+
+type Matched struct {
+	At      time.Time
+	Players int
 }
 
 type Node struct {
@@ -27,6 +46,60 @@ func (q *Queue) Count() int {
 	return len(q.sockets)
 }
 
+// Sockets in the order they were pushed
+func (q *Queue) Sockets() []*Socket {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	sockets := make([]*Socket, 0, len(q.sockets))
+	for node := q.Head; node != nil; node = node.Next {
+		sockets = append(sockets, node.Socket)
+	}
+	return sockets
+}
+
+// Remembers that players left the queue for a match
+func (q *Queue) Record(players int) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	q.trim()
+	q.matched = append(q.matched, Matched{time.Now(), players})
+}
+
+// Forgets the matches older than THROUGHPUT_WINDOW. Must hold the mutex.
+func (q *Queue) trim() {
+	now := time.Now()
+	for len(q.matched) > 0 && now.Sub(q.matched[0].At) > THROUGHPUT_WINDOW {
+		q.matched = q.matched[1:]
+	}
+}
+
+// Estimates how long until the players ahead and those of the entry are
+// matched, from how many players were matched over the last
+// THROUGHPUT_WINDOW, or since the queue opened. 0 if no one was.
+func (q *Queue) EstimateWait(players int) time.Duration {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	q.trim()
+
+	matched := 0
+	for _, m := range q.matched {
+		matched += m.Players
+	}
+	if matched == 0 {
+		return 0
+	}
+
+	span := time.Since(q.opened)
+	if span > THROUGHPUT_WINDOW {
+		span = THROUGHPUT_WINDOW
+	}
+
+	return span * time.Duration(players) / time.Duration(matched)
+}
+
 func (q *Queue) Pop() *Socket {
 	q.mut.Lock()
 
@@ -88,74 +161,590 @@ func (q *Queue) Push(socket *Socket) {
 	q.sockets[socket] = node
 }
 
+// QueueManager keeps independent named queues, each with its own player
+// count, rules and matchmaking strategy. A party waits in a queue as a
+// single entry, its leader, so it's always matched and dequeued as a whole.
 type QueueManager struct {
-	queue *Queue
-	mutex *sync.Mutex
+	queues       map[string]*Queue
+	names        []string
+	waiting      map[*Socket]*Queue
+	joined       map[*Socket]time.Time
+	mutex        *sync.Mutex
+	defaultQueue string
+	draining     bool
+	ratings      Rater
+	parties      PartyFinder
+	busy         BusyCheckers
+
+	// leader of the entry each waiting player is part of, and the players of
+	// each entry
+	entries map[*Socket]*Socket
+	groups  map[*Socket][]*Socket
+
+	// how often waiting players are told where they stand, 0 for never
+	statusInterval time.Duration
+
+	// how long a player waits before bots fill their match, 0 for never
+	botWait       time.Duration
+	botDifficulty Difficulty
+	botThinkTime  time.Duration
 }
 
-func NewQueueManager() *QueueManager {
-	return &QueueManager{
-		mutex: new(sync.Mutex),
-		queue: &Queue{
-			mut:     new(sync.Mutex),
-			sockets: make(map[*Socket]*Node),
-		},
+func NewQueue() *Queue {
+	return &Queue{
+		mut:      new(sync.Mutex),
+		sockets:  make(map[*Socket]*Node),
+		strategy: &FifoStrategy{},
+		opened:   time.Now(),
+	}
+}
+
+// Creates an empty queue matching players as the settings say
+func NewNamedQueue(settings QueueSettings, config Config) *Queue {
+	queue := NewQueue()
+	queue.Settings = settings
+
+	// the config was validated, so the strategy exists
+	queue.strategy, _ = NewStrategy(settings.Matchmaking, config)
+
+	return queue
+}
+
+func NewQueueManager(config Config) *QueueManager {
+	manager := &QueueManager{
+		mutex:        new(sync.Mutex),
+		queues:       make(map[string]*Queue),
+		names:        make([]string, 0),
+		waiting:      make(map[*Socket]*Queue),
+		joined:       make(map[*Socket]time.Time),
+		entries:      make(map[*Socket]*Socket),
+		groups:       make(map[*Socket][]*Socket),
+		defaultQueue: config.DefaultQueue,
+
+		statusInterval: config.QueueStatusInterval,
+
+		botWait:       config.BotWait,
+		botDifficulty: config.BotDifficulty,
+		botThinkTime:  config.BotThinkTime,
+	}
+
+	for _, settings := range config.Queues() {
+		manager.queues[settings.Name] = NewNamedQueue(settings, config)
+		manager.names = append(manager.names, settings.Name)
+	}
+
+	return manager
+}
+
+// BusyChecker is what the queue learns who's already matched or playing from
+type BusyChecker interface {
+	Busy(socket *Socket) bool
+}
+
+// BusyCheckers finds a player busy if any of its checkers does
+type BusyCheckers []BusyChecker
+
+func (c BusyCheckers) Busy(socket *Socket) bool {
+	for _, checker := range c {
+		if checker.Busy(socket) {
+			return true
+		}
 	}
+	return false
+}
+
+// Keeps party leaders from queueing up members the checkers find busy
+func (q *QueueManager) UseBusy(checkers ...BusyChecker) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.busy = append(q.busy, checkers...)
+}
+
+// Lets the queue read player ratings. Without it everyone is rated the same.
+func (q *QueueManager) UseRatings(ratings Rater) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.ratings = ratings
 }
 
-func (q *QueueManager) Push(socket *Socket) {
+// Lets party leaders queue up for their whole party. Without it everyone
+// queues up alone.
+func (q *QueueManager) UseParties(parties PartyFinder) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
-	q.queue.Push(socket)
+	q.parties = parties
 }
 
-func (q *QueueManager) Pop() *Socket {
+func (q *QueueManager) Rating(socket *Socket) Rating {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
-	return q.queue.Pop()
+	return q.rating(socket)
+}
+
+// Must hold the mutex
+func (q *QueueManager) rating(socket *Socket) Rating {
+	if q.ratings == nil {
+		return NewRating()
+	}
+	return q.ratings.Rating(socket)
+}
+
+// Average rating of the players of an entry. Must hold the mutex.
+func (q *QueueManager) groupRating(players []*Socket) Rating {
+	rating := Rating{}
+
+	for _, player := range players {
+		r := q.rating(player)
+		rating.Rating += r.Rating / float64(len(players))
+		rating.Deviation += r.Deviation / float64(len(players))
+		rating.Volatility += r.Volatility / float64(len(players))
+	}
+	return rating
 }
 
-func (q *QueueManager) Remove(socket *Socket) {
+// The socket's party if it leads one, otherwise the socket alone
+func (q *QueueManager) party(socket *Socket) []*Socket {
+	q.mutex.Lock()
+	parties := q.parties
+	q.mutex.Unlock()
+
+	if parties != nil {
+		if leader, members := parties.PartyOf(socket); leader == socket {
+			return members
+		}
+	}
+	return []*Socket{socket}
+}
+
+// Dequeues the socket along with its party, returning everyone dequeued
+func (q *QueueManager) Remove(socket *Socket) []*Socket {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
-	q.queue.Remove(socket)
+	return q.remove(socket)
+}
+
+// Must hold the mutex
+func (q *QueueManager) remove(socket *Socket) []*Socket {
+	leader, ok := q.entries[socket]
+	if !ok {
+		return nil
+	}
+
+	players := q.groups[leader]
+	q.waiting[leader].Remove(leader)
+
+	for _, player := range players {
+		delete(q.waiting, player)
+		delete(q.entries, player)
+	}
+	delete(q.groups, leader)
+	delete(q.joined, leader)
+
+	return players
+}
+
+// When the socket's entry queued up, zero if it isn't waiting
+func (q *QueueManager) Joined(socket *Socket) time.Time {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.joined[q.entries[socket]]
 }
 
 func (q *QueueManager) Count() int {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
-	return q.queue.Count()
+	return len(q.waiting)
+}
+
+// Players waiting in the queue. Must hold the mutex.
+func (q *QueueManager) size(queue *Queue) int {
+	size := 0
+	for _, leader := range queue.Sockets() {
+		size += len(q.groups[leader])
+	}
+	return size
+}
+
+// Every queue and how many players wait in it, in the order they were
+// configured
+func (q *QueueManager) List() []protocol.QueueInfo {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	queues := make([]protocol.QueueInfo, 0, len(q.names))
+	for _, name := range q.names {
+		queue := q.queues[name]
+
+		queues = append(queues, protocol.QueueInfo{
+			Name:    name,
+			Players: queue.Settings.Players,
+			Waiting: q.size(queue),
+			Ranked:  queue.Settings.Matchmaking == RATING_STRATEGY,
+			Rules:   queue.Settings.Rules,
+		})
+	}
+	return queues
+}
+
+// Where the socket's entry stands in its queue, along with its players.
+// Position counts the players ahead and those of the entry. false once the
+// entry is no longer the one that queued up at joined.
+func (q *QueueManager) Status(socket *Socket, joined time.Time) (protocol.QueueStatus, []*Socket, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	queue, ok := q.waiting[socket]
+	if !ok || !q.joined[q.entries[socket]].Equal(joined) {
+		return protocol.QueueStatus{}, nil, false
+	}
+
+	leader := q.entries[socket]
+	position := 0
+	for _, other := range queue.Sockets() {
+		position += len(q.groups[other])
+		if other == leader {
+			break
+		}
+	}
+
+	return protocol.QueueStatus{
+		Queue:         queue.Settings.Name,
+		Position:      position,
+		Size:          q.size(queue),
+		EstimatedWait: queue.EstimateWait(position).Round(time.Second),
+	}, q.groups[leader], true
+}
+
+// Pushes the socket, along with its party if it leads one, in the named
+// queue, empty for the default one. Once the queue's strategy can make a
+// match, its players are popped in the same critical section so concurrent
+// workers can't split a match.
+func (q *QueueManager) Enqueue(socket *Socket, name string) (*Queue, []*Socket, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	players := []*Socket{socket}
+
+	if q.parties != nil {
+		leader, members := q.parties.PartyOf(socket)
+
+		if leader != nil && leader != socket {
+			return nil, nil, protocol.NewError(protocol.NOT_AUTHORIZED, "Only your party leader can queue up")
+		}
+		if leader != nil {
+			players = members
+		}
+	}
+
+	for _, player := range players {
+		if !q.busy.Busy(player) {
+			continue
+		}
+		if player == socket {
+			return nil, nil, protocol.NewError(protocol.PLAYER_BUSY, "You're already in a match")
+		}
+		return nil, nil, protocol.NewError(protocol.PLAYER_BUSY, fmt.Sprintf("Player %s is already in a match", player.PlayerId()))
+	}
+
+	if name == "" {
+		name = q.defaultQueue
+	}
+
+	queue, ok := q.queues[name]
+	if !ok {
+		return nil, nil, protocol.NewError(protocol.QUEUE_NOT_FOUND, fmt.Sprintf("There's no queue named \"%s\"", name))
+	}
+
+	if len(players) > queue.Settings.Players {
+		return nil, nil, protocol.NewError(protocol.PARTY_TOO_BIG, fmt.Sprintf("Games of %s have %d players", name, queue.Settings.Players))
+	}
+
+	for _, player := range players {
+		q.remove(player)
+	}
+
+	queue.Push(socket)
+	for _, player := range players {
+		q.waiting[player] = queue
+		q.entries[player] = socket
+	}
+	q.groups[socket] = players
+	q.joined[socket] = time.Now()
+
+	return queue, q.match(queue), nil
+}
+
+// Tries to match the socket again in case its window widened, returning
+// false once it's no longer waiting
+func (q *QueueManager) Search(socket *Socket) (*Queue, []*Socket, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	queue, ok := q.waiting[socket]
+	if !ok || q.draining {
+		return queue, nil, false
+	}
+
+	players := q.match(queue)
+
+	// the match may have been made of others, leaving the socket waiting
+	_, waiting := q.waiting[socket]
+	return queue, players, waiting
+}
+
+// Removes the players the strategy picks off the queue, if any. Must hold
+// the mutex.
+func (q *QueueManager) match(queue *Queue) []*Socket {
+	if q.size(queue) < queue.Settings.Players {
+		return nil
+	}
+
+	leaders := queue.Sockets()
+	waiting := make([]Waiting, 0, len(leaders))
+
+	for _, leader := range leaders {
+		waiting = append(waiting, Waiting{
+			Players: q.groups[leader],
+			Rating:  q.groupRating(q.groups[leader]),
+			Since:   q.joined[leader],
+		})
+	}
+
+	players := queue.strategy.Match(waiting, queue.Settings.Players)
+
+	for _, player := range players {
+		q.remove(player)
+	}
+	if players != nil {
+		queue.Record(len(players))
+	}
+
+	return players
+}
+
+// Pops the socket's entry and whoever else fits in a match if the socket
+// has waited long enough for bots, returning the players and how many bots
+// they need
+func (q *QueueManager) Backfill(socket *Socket) (*Queue, []*Socket, int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	queue, ok := q.waiting[socket]
+	if !ok || q.draining || time.Since(q.joined[q.entries[socket]]) < q.botWait {
+		return queue, nil, 0
+	}
+
+	count := queue.Settings.Players
+	leader := q.entries[socket]
+	players := q.remove(leader)
+
+	for _, other := range queue.Sockets() {
+		if len(players)+len(q.groups[other]) <= count {
+			players = append(players, q.remove(other)...)
+		}
+	}
+
+	queue.Record(len(players))
+
+	return queue, players, count - len(players)
+}
+
+// Refuses new players and empties the queues
+func (q *QueueManager) Drain() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.draining = true
+
+	for socket := range q.waiting {
+		q.remove(socket)
+	}
+}
+
+func (q *QueueManager) Draining() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.draining
+}
+
+func (q *QueueManager) searchLater(socket *Socket, server *Server) {
+	time.AfterFunc(SEARCH_INTERVAL, func() {
+		server.Dispatch(Event{
+			Type:   "search",
+			Socket: socket,
+		})
+	})
+}
+
+// Tells the entry where it stands every statusInterval until it leaves
+// the queue, starting now
+func (q *QueueManager) reportStatus(socket *Socket, joined time.Time, server *Server) {
+	status, players, ok := q.Status(socket, joined)
+	if !ok {
+		return
+	}
+
+	NewSockets(players).Send(Message{
+		Type:    "queue_status",
+		Payload: status,
+	})
+
+	time.AfterFunc(q.statusInterval, func() {
+		server.Dispatch(Event{
+			Type:    "queue_status",
+			Socket:  socket,
+			Payload: &QueueStatusPayload{Joined: joined},
+		})
+	})
+}
+
+func matchFound(socket *Socket, queue *Queue, players []*Socket) Event {
+	return Event{
+		Type:   "match_found",
+		Socket: socket,
+		Payload: &MatchFoundPayload{
+			Players: players,
+			Queue:   queue.Settings.Name,
+			Rules:   queue.Settings.Rules,
+		},
+	}
+}
+
+// Tells the players taken out of the queue, except the one who asked
+func dequeued(players []*Socket, except *Socket, message string) {
+	for _, player := range players {
+		if player == except {
+			continue
+		}
+
+		player.Send(Message{
+			Type: "dequeued",
+			Payload: protocol.Dequeued{
+				Message: message,
+			},
+		})
+	}
 }
 
 func (q *QueueManager) Process(event Event, server *Server) {
 	switch event.Type {
+	case "draining":
+		q.Drain()
 	case "dequeue", "disconnected":
-		q.Remove(event.Socket)
-	case "queue_up":
-		q.Push(event.Socket)
+		players := q.Remove(event.Socket)
+		event.Ack()
 
+		dequeued(players, event.Socket, "A member of your party left the queue")
+	case "game_start":
+		// players of private lobbies may have been waiting for a public match
+		payload := event.Payload.(*GameStartPayload)
+
+		for _, player := range payload.Players.conns {
+			dequeued(q.Remove(player), nil, "Your game started")
+		}
+	case "party_changed":
+		payload := event.Payload.(*PartyChangedPayload)
+
+		for _, member := range payload.Members {
+			dequeued(q.Remove(member), nil, "Your party changed, queue up again")
+		}
+	case "list_queues":
+		event.Ack()
 		event.Socket.Send(Message{
-			Type: "wait_for_match",
+			Type: "queues",
+			Payload: protocol.Queues{
+				Default: q.defaultQueue,
+				Queues:  q.List(),
+			},
 		})
+	case "queue_up":
+		if q.Draining() {
+			event.Reject(protocol.SHUTTING_DOWN, "Server is shutting down")
+			return
+		}
 
-		if q.Count() == NUM_OF_PLAYERS {
-			players := make([]*Socket, 0)
+		// bots only play the match they were created for, and players who
+		// left for good may still be requeued by a canceled match
+		if event.Socket.Bot() || event.Socket.Gone() {
+			return
+		}
 
-			for i := 0; i < NUM_OF_PLAYERS; i++ {
-				players = append(players, q.Pop())
-			}
+		name := ""
+		if payload, ok := event.Payload.(*protocol.QueueUp); ok {
+			name = payload.Queue
+		}
+
+		queue, players, err := q.Enqueue(event.Socket, name)
+
+		var rejection *protocol.Error
+		if errors.As(err, &rejection) {
+			event.Reject(rejection.Code, rejection.Message)
+			return
+		}
+
+		event.Ack()
+
+		NewSockets(q.party(event.Socket)).Send(Message{
+			Type: "wait_for_match",
+		})
+
+		if players != nil {
+			server.Dispatch(matchFound(event.Socket, queue, players))
+			return
+		}
 
-			server.Dispatch(Event{
-				Type:   "match_found",
-				Socket: event.Socket,
-				Payload: map[string]interface{}{
-					"players": players,
-				},
+		socket := event.Socket
+		if q.botWait > 0 {
+			time.AfterFunc(q.botWait, func() {
+				server.Dispatch(Event{
+					Type:   "backfill",
+					Socket: socket,
+				})
 			})
 		}
+		if queue.strategy.Widens() {
+			q.searchLater(socket, server)
+		}
+		if q.statusInterval > 0 {
+			q.reportStatus(socket, q.Joined(socket), server)
+		}
+
+	case "queue_status":
+		payload := event.Payload.(*QueueStatusPayload)
+		q.reportStatus(event.Socket, payload.Joined, server)
+
+	case "search":
+		queue, players, waiting := q.Search(event.Socket)
+
+		if players != nil {
+			server.Dispatch(matchFound(event.Socket, queue, players))
+		}
+		if waiting {
+			q.searchLater(event.Socket, server)
+		}
+
+	case "backfill":
+		queue, players, missing := q.Backfill(event.Socket)
+
+		if players == nil {
+			return
+		}
+
+		for i := 0; i < missing; i++ {
+			bot := NewBot(q.botDifficulty, q.botThinkTime, server.Dispatch)
+			go bot.Run()
+
+			players = append(players, bot.Socket)
+		}
+
+		server.Dispatch(matchFound(event.Socket, queue, players))
 	}
 }
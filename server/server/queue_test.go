@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"example.com/game/client/protocol"
 	"github.com/gorilla/websocket"
 )
 
@@ -239,13 +240,13 @@ func TestRemoveLast(t *testing.T) {
 }
 
 func TestQueueCommand(t *testing.T) {
-	queueManager := NewQueueManager()
-	server := NewServer([]EventHandler{
+	queueManager := NewQueueManager(testConfig())
+	server := NewServer(testConfig(), []EventHandler{
 		queueManager,
 	})
 	defer server.Close()
 
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
@@ -258,20 +259,20 @@ func TestQueueCommand(t *testing.T) {
 }
 
 func TestQueuesUser(t *testing.T) {
-	queueManager := NewQueueManager()
-	server := NewServer([]EventHandler{
+	queueManager := NewQueueManager(testConfig())
+	server := NewServer(testConfig(), []EventHandler{
 		queueManager,
 	})
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
 	c := NewTestClient()
 	c.QueueUp()
 
-	if queueManager.queue.Count() != 1 {
-		t.Errorf("Expected queue to have one, got %d", queueManager.queue.Count())
+	if queueManager.queues[CLASSIC_QUEUE].Count() != 1 {
+		t.Errorf("Expected queue to have one, got %d", queueManager.queues[CLASSIC_QUEUE].Count())
 	}
 }
 
@@ -297,15 +298,15 @@ func (m *FakeMatchMaker) Process(event Event, server *Server) {
 }
 
 func TestDispatchesMatchFound(t *testing.T) {
-	queueManager := NewQueueManager()
+	queueManager := NewQueueManager(testConfig())
 	fakeMaker := &FakeMatchMaker{0, new(sync.Mutex)}
 
-	server := NewServer([]EventHandler{
+	server := NewServer(testConfig(), []EventHandler{
 		queueManager,
 		fakeMaker,
 	})
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
@@ -323,13 +324,13 @@ func TestDispatchesMatchFound(t *testing.T) {
 }
 
 func TestDisconnectRemovesFromQueue(t *testing.T) {
-	queueManager := NewQueueManager()
+	queueManager := NewQueueManager(testConfig())
 
-	server := NewServer([]EventHandler{
+	server := NewServer(testConfig(), []EventHandler{
 		queueManager,
 	})
 	defer server.Close()
-	go server.Listen("0.0.0.0:8080")
+	go server.Listen()
 
 	time.Sleep(time.Millisecond)
 
@@ -341,7 +342,137 @@ func TestDisconnectRemovesFromQueue(t *testing.T) {
 	// TODO: How to not do this?
 	time.Sleep(time.Millisecond)
 
-	if queueManager.queue.Count() != 0 {
-		t.Errorf("Expected queue to have count 0, got %d", len(queueManager.queue.sockets))
+	if queueManager.queues[CLASSIC_QUEUE].Count() != 0 {
+		t.Errorf("Expected queue to have count 0, got %d", queueManager.queues[CLASSIC_QUEUE].Count())
+	}
+}
+
+func TestNamedQueues(t *testing.T) {
+	queueManager := NewQueueManager(testConfig())
+
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+	p3 := newOfflineSocket()
+
+	queueManager.Enqueue(p1, FFA_QUEUE)
+	queueManager.Enqueue(p2, "")
+
+	queue, players, _ := queueManager.Enqueue(p3, FFA_QUEUE)
+	if players != nil {
+		t.Errorf("Expected the four player queue to keep waiting, got %v", players)
+	}
+	if !queue.Settings.Rules.KeepPlaying {
+		t.Error("Expected the free-for-all to rank every player")
+	}
+
+	if _, _, err := queueManager.Enqueue(p1, "nope"); err == nil {
+		t.Error("Expected no queue by an unknown name")
+	}
+
+	waiting := make(map[string]int)
+	for _, info := range queueManager.List() {
+		waiting[info.Name] = info.Waiting
+	}
+
+	if waiting[CLASSIC_QUEUE] != 1 || waiting[FFA_QUEUE] != 2 || waiting[RANKED_QUEUE] != 0 {
+		t.Errorf("Expected 1 classic and 2 free-for-all players waiting, got %v", waiting)
+	}
+}
+
+func TestListQueues(t *testing.T) {
+	server := NewServer(testConfig(), []EventHandler{
+		NewQueueManager(testConfig()),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(time.Millisecond)
+
+	c := NewTestClient()
+
+	res := c.QueueUpFor("nope")
+	if res.Type != "error" || res.Payload.(*protocol.Error).Code != protocol.QUEUE_NOT_FOUND {
+		t.Errorf("Expected \"%s\" error, got %v", protocol.QUEUE_NOT_FOUND, res)
+	}
+
+	c.Client.Send(Message{Type: "list_queues"})
+
+	res = c.GetIncoming()
+	if res.Type != "queues" {
+		t.Fatalf("Expected \"queues\", got \"%s\"", res.Type)
+	}
+
+	queues := res.Payload.(*protocol.Queues)
+	if queues.Default != CLASSIC_QUEUE || len(queues.Queues) != 4 {
+		t.Errorf("Expected the four queues, got %+v", queues)
+	}
+}
+
+func TestEstimateWait(t *testing.T) {
+	queue := NewQueue()
+
+	if wait := queue.EstimateWait(2); wait != 0 {
+		t.Errorf("Expected no estimate before any match, got %s", wait)
+	}
+
+	queue.opened = time.Now().Add(-time.Minute)
+	queue.Record(2)
+	queue.Record(2)
+
+	// four players a minute
+	if wait := queue.EstimateWait(2); wait < 29*time.Second || wait > 31*time.Second {
+		t.Errorf("Expected about 30s for two players, got %s", wait)
+	}
+
+	queue.matched[0].At = time.Now().Add(-2 * THROUGHPUT_WINDOW)
+
+	// the first match is too old to count
+	if wait := queue.EstimateWait(2); wait < 59*time.Second || wait > 61*time.Second {
+		t.Errorf("Expected about 1m for two players, got %s", wait)
+	}
+	queue.matched[0].At = time.Now().Add(-2 * THROUGHPUT_WINDOW)
+	queue.Record(2)
+
+	if len(queue.matched) != 1 {
+		t.Errorf("Expected old matches to be forgotten when recording, got %d", len(queue.matched))
+	}
+}
+
+func TestQueueStatus(t *testing.T) {
+	config := testConfig()
+	config.QueueStatusInterval = 20 * time.Millisecond
+
+	server := NewServer(config, []EventHandler{
+		NewQueueManager(config),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(time.Millisecond)
+
+	first := NewTestClient()
+	second := NewTestClient()
+
+	first.QueueUpFor(FFA_QUEUE)
+	status := waitFor(first, "queue_status").Payload.(*protocol.QueueStatus)
+
+	if status.Queue != FFA_QUEUE || status.Position != 1 || status.Size != 1 || status.EstimatedWait != 0 {
+		t.Errorf("Expected to be alone in %s with no estimate, got %+v", FFA_QUEUE, status)
+	}
+
+	second.QueueUpFor(FFA_QUEUE)
+	status = waitFor(second, "queue_status").Payload.(*protocol.QueueStatus)
+
+	if status.Position != 2 || status.Size != 2 {
+		t.Errorf("Expected to be second of two, got %+v", status)
+	}
+
+	// the first player hears about the second with the next update
+	status = waitFor(first, "queue_status").Payload.(*protocol.QueueStatus)
+	for status.Size != 2 {
+		status = waitFor(first, "queue_status").Payload.(*protocol.QueueStatus)
+	}
+	if status.Position != 1 {
+		t.Errorf("Expected to stay first, got %+v", status)
 	}
 }
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"math"
+	"sync"
+
+	"example.com/game/client/protocol"
+)
+
+// Glicko-2 parameters, see http://www.glicko.net/glicko/glicko2.pdf
+const (
+	DEFAULT_RATING     = 1500.0
+	DEFAULT_DEVIATION  = 350.0
+	DEFAULT_VOLATILITY = 0.06
+	// constrains how much the volatility changes between games
+	RATING_TAU = 0.5
+	// converts ratings to and from the Glicko-2 scale
+	GLICKO_SCALE       = 173.7178
+	VOLATILITY_EPSILON = 0.000001
+)
+
+type Rating struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+	Games      int
+}
+
+func NewRating() Rating {
+	return Rating{
+		Rating:     DEFAULT_RATING,
+		Deviation:  DEFAULT_DEVIATION,
+		Volatility: DEFAULT_VOLATILITY,
+	}
+}
+
+// Rater is what the matchmaker reads player ratings from
+type Rater interface {
+	Rating(socket *Socket) Rating
+}
+
+// Result of a game against one opponent, 1 for a win, 0.5 for a tie and
+// 0 for a loss
+type Outcome struct {
+	Opponent Rating
+	Score    float64
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func glickoE(mu float64, opponent float64, phi float64) float64 {
+	return 1 / (1 + math.Exp(-glickoG(phi)*(mu-opponent)))
+}
+
+// Rates the player after a game, counting each opponent as a separate
+// result of the same rating period
+func (r Rating) Update(outcomes []Outcome) Rating {
+	if len(outcomes) == 0 {
+		return r
+	}
+
+	mu := (r.Rating - DEFAULT_RATING) / GLICKO_SCALE
+	phi := r.Deviation / GLICKO_SCALE
+	sigma := r.Volatility
+
+	variance := 0.0
+	improvement := 0.0
+
+	for _, outcome := range outcomes {
+		muJ := (outcome.Opponent.Rating - DEFAULT_RATING) / GLICKO_SCALE
+		phiJ := outcome.Opponent.Deviation / GLICKO_SCALE
+		g := glickoG(phiJ)
+		e := glickoE(mu, muJ, phiJ)
+
+		variance += g * g * e * (1 - e)
+		improvement += g * (outcome.Score - e)
+	}
+
+	v := 1 / variance
+	delta := v * improvement
+
+	sigma = volatility(delta, phi, v, sigma)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * improvement
+
+	return Rating{
+		Rating:     GLICKO_SCALE*mu + DEFAULT_RATING,
+		Deviation:  GLICKO_SCALE * phi,
+		Volatility: sigma,
+		Games:      r.Games + 1,
+	}
+}
+
+// Finds the new volatility with the Illinois algorithm
+func volatility(delta float64, phi float64, v float64, sigma float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+
+		return ex*(delta*delta-d)/(2*d*d) - (x-a)/(RATING_TAU*RATING_TAU)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*RATING_TAU) < 0 {
+			k++
+		}
+		B = a - k*RATING_TAU
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > VOLATILITY_EPSILON {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA = fA / 2
+		}
+		B, fB = C, fC
+	}
+
+	return math.Exp(A / 2)
+}
+
+// RatingManager rates players from the places they finish games in. An
+// N-player game counts as a game against each other player, beating those
+// placed below and tying those placed the same.
+//
+// Ratings last as long as the player's session. Players have no identity
+// beyond it, since their ID is issued with the session, so a rating is
+// dropped once its session is over for good.
+type RatingManager struct {
+	mutex   *sync.Mutex
+	ratings map[*Socket]Rating
+}
+
+func NewRatingManager() *RatingManager {
+	return &RatingManager{
+		mutex:   new(sync.Mutex),
+		ratings: make(map[*Socket]Rating),
+	}
+}
+
+func (r *RatingManager) Rating(socket *Socket) Rating {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if rating, ok := r.ratings[socket]; ok {
+		return rating
+	}
+	return NewRating()
+}
+
+// Updates the ratings of the players from their places. Bots aren't rated
+// and don't count as opponents, and neither are players who left for good,
+// so their ratings aren't kept after Remove.
+func (r *RatingManager) Record(places map[*Socket]int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	before := make(map[*Socket]Rating)
+	for player := range places {
+		if player.Bot() || player.Gone() {
+			continue
+		}
+		if rating, ok := r.ratings[player]; ok {
+			before[player] = rating
+		} else {
+			before[player] = NewRating()
+		}
+	}
+
+	for player, rating := range before {
+		outcomes := make([]Outcome, 0, len(before)-1)
+
+		for opponent, opponentRating := range before {
+			if opponent == player {
+				continue
+			}
+
+			score := 0.5
+			if places[player] < places[opponent] {
+				score = 1
+			} else if places[player] > places[opponent] {
+				score = 0
+			}
+
+			outcomes = append(outcomes, Outcome{opponentRating, score})
+		}
+
+		r.ratings[player] = rating.Update(outcomes)
+	}
+}
+
+func (r *RatingManager) Remove(socket *Socket) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.ratings, socket)
+}
+
+func (r *RatingManager) Process(event Event, server *Server) {
+	switch event.Type {
+	case "disconnected":
+		r.Remove(event.Socket)
+
+	case "game_over":
+		payload := event.Payload.(*GameOverPayload)
+		r.Record(payload.Places)
+
+	case "profile":
+		rating := r.Rating(event.Socket)
+		event.Ack()
+
+		event.Socket.Send(Message{
+			Type: "profile",
+			Payload: protocol.Profile{
+				Rating:    math.Round(rating.Rating),
+				Deviation: math.Round(rating.Deviation),
+				Games:     rating.Games,
+			},
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRatingUpdate(t *testing.T) {
+	// example from the Glicko-2 paper
+	player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+
+	rated := player.Update([]Outcome{
+		{Rating{Rating: 1400, Deviation: 30}, 1},
+		{Rating{Rating: 1550, Deviation: 100}, 0},
+		{Rating{Rating: 1700, Deviation: 300}, 0},
+	})
+
+	if math.Abs(rated.Rating-1464.06) > 0.01 {
+		t.Errorf("Expected rating 1464.06, got %.2f", rated.Rating)
+	}
+	if math.Abs(rated.Deviation-151.52) > 0.01 {
+		t.Errorf("Expected deviation 151.52, got %.2f", rated.Deviation)
+	}
+	if math.Abs(rated.Volatility-0.05999) > 0.00001 {
+		t.Errorf("Expected volatility 0.05999, got %.5f", rated.Volatility)
+	}
+	if rated.Games != 1 {
+		t.Errorf("Expected 1 game, got %d", rated.Games)
+	}
+}
+
+func TestRecordPlacements(t *testing.T) {
+	ratings := NewRatingManager()
+
+	first := newOfflineSocket()
+	second := newOfflineSocket()
+	third := newOfflineSocket()
+	bot := NewBotSocket()
+
+	ratings.Record(map[*Socket]int{
+		first:  1,
+		second: 2,
+		third:  2,
+		bot:    3,
+	})
+
+	if ratings.Rating(first).Rating <= DEFAULT_RATING {
+		t.Errorf("Expected the winner to gain rating, got %.2f", ratings.Rating(first).Rating)
+	}
+	if ratings.Rating(second).Rating >= DEFAULT_RATING {
+		t.Errorf("Expected the losers to lose rating, got %.2f", ratings.Rating(second).Rating)
+	}
+	if ratings.Rating(second) != ratings.Rating(third) {
+		t.Errorf("Expected tied players to be rated the same")
+	}
+	if ratings.Rating(first).Deviation >= DEFAULT_DEVIATION {
+		t.Errorf("Expected the deviation to shrink, got %.2f", ratings.Rating(first).Deviation)
+	}
+	if ratings.Rating(bot).Games != 0 {
+		t.Errorf("Expected bots not to be rated")
+	}
+}
+
+func TestRecordSkipsPlayersWhoLeft(t *testing.T) {
+	ratings := NewRatingManager()
+
+	winner := newOfflineSocket()
+	left := newOfflineSocket()
+
+	// the game ended after the player's session was over and removed
+	left.End()
+	ratings.Remove(left)
+
+	ratings.Record(map[*Socket]int{
+		winner: 1,
+		left:   2,
+	})
+
+	if _, ok := ratings.ratings[left]; ok {
+		t.Error("Expected no rating to be kept for a player who left")
+	}
+}
+
+func TestProfileAfterGame(t *testing.T) {
+	gameManager := NewGameManager(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		gameManager,
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
+		NewRatingManager(),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+
+	profile := c1.Profile()
+	if profile.Rating != DEFAULT_RATING || profile.Games != 0 {
+		t.Errorf("Expected a new player to be unrated, got %+v", profile)
+	}
+
+	gameId := startGame(c1, c2)
+	gameManager.Games[gameId].Answer = 40
+
+	c1.Guess(40, gameId)
+	time.Sleep(10 * time.Millisecond)
+
+	winner := c1.Profile()
+	loser := c2.Profile()
+
+	if winner.Games != 1 || winner.Rating <= DEFAULT_RATING {
+		t.Errorf("Expected the winner to gain rating, got %+v", winner)
+	}
+	if loser.Games != 1 || loser.Rating >= DEFAULT_RATING {
+		t.Errorf("Expected the loser to lose rating, got %+v", loser)
+	}
+}
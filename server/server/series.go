@@ -0,0 +1,183 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"example.com/game/client/protocol"
+)
+
+// Series is a best-of-N run of games between the same players. Games of a
+// single round are a series of one.
+type Series struct {
+	mutex *sync.Mutex
+
+	Id      string
+	Rules   GameRules
+	BestOf  int
+	Round   int
+	Players *Sockets
+	Wins    map[*Socket]int
+
+	// set once a player left, so no more rounds are played
+	abandoned bool
+}
+
+func NewSeries(players *Sockets, rules GameRules, bestOf int) *Series {
+	return &Series{
+		mutex: new(sync.Mutex),
+
+		Id:      NewId(),
+		Rules:   rules,
+		BestOf:  bestOf,
+		Players: players,
+		Wins:    make(map[*Socket]int),
+	}
+}
+
+// Counts the round won by winner, nil for a draw, and tells whether the
+// series is over. Players of series longer than one round get the score.
+func (s *Series) Record(winner *Socket) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Round++
+	if winner != nil {
+		s.Wins[winner]++
+	}
+
+	over := s.Round >= s.BestOf || winner != nil && s.Wins[winner] > s.BestOf/2
+
+	if s.BestOf > 1 {
+		s.SendScore(over)
+	}
+	return over
+}
+
+func (s *Series) Abandon() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.abandoned = true
+}
+
+func (s *Series) Abandoned() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.abandoned
+}
+
+// Player leading the series, nil when tied
+func (s *Series) Leader() *Socket {
+	var leader *Socket
+	best := 0
+
+	for _, player := range s.Players.conns {
+		if s.Wins[player] > best {
+			leader = player
+			best = s.Wins[player]
+		} else if s.Wins[player] == best {
+			leader = nil
+		}
+	}
+	return leader
+}
+
+func (s *Series) SendScore(over bool) {
+	scores := make([]protocol.SeriesScore, 0, len(s.Players.conns))
+	winner := 0
+
+	for i, player := range s.Players.conns {
+		scores = append(scores, protocol.SeriesScore{
+			Player: i + 1,
+			Wins:   s.Wins[player],
+		})
+
+		if over && player == s.Leader() {
+			winner = i + 1
+		}
+	}
+
+	s.Players.Send(Message{
+		Type: "series_score",
+		Payload: protocol.Series{
+			SeriesId: s.Id,
+			Round:    s.Round,
+			BestOf:   s.BestOf,
+			Scores:   scores,
+			Over:     over,
+			Winner:   winner,
+		},
+	})
+}
+
+// Rematch is offered to the players once a series is over. If all of them
+// accept before the timeout, a new series starts with the same players.
+type Rematch struct {
+	mutex *sync.Mutex
+	timer *time.Timer
+
+	Id       string
+	Rules    GameRules
+	Players  *Sockets
+	Accepted *Sockets
+}
+
+func NewRematch(series *Series) *Rematch {
+	return &Rematch{
+		mutex: new(sync.Mutex),
+
+		Id:       NewId(),
+		Rules:    series.Rules,
+		Players:  series.Players,
+		Accepted: NewSockets([]*Socket{}),
+	}
+}
+
+// Sends the offer and calls expire once the timeout is up
+func (r *Rematch) Offer(timeout time.Duration, expire func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.timer = time.AfterFunc(timeout, expire)
+
+	r.Players.Send(Message{
+		Type: "rematch_offer",
+		Payload: protocol.RematchOffer{
+			RematchId: r.Id,
+			Deadline:  time.Now().Add(timeout),
+		},
+	})
+}
+
+// Counts the player in and tells whether everyone accepted
+func (r *Rematch) Accept(player *Socket) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.Accepted.Has(player) {
+		r.Accepted.Add(player)
+	}
+	return r.Accepted.Count() == r.Players.Count()
+}
+
+func (r *Rematch) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+}
+
+func (r *Rematch) Cancel() {
+	r.Stop()
+
+	r.Players.Send(Message{
+		Type: "rematch_canceled",
+		Payload: protocol.RematchCanceled{
+			RematchId: r.Id,
+		},
+	})
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"example.com/game/client/client"
+	"example.com/game/client/protocol"
+)
+
+func TestSeriesRecord(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	series := NewSeries(NewSockets([]*Socket{p1, p2}), GameRules{}, 3)
+
+	if series.Record(p1) {
+		t.Error("Expected series to go on after the first round")
+	}
+	if series.Record(nil) {
+		t.Error("Expected draw to not decide the series")
+	}
+	if !series.Record(p1) {
+		t.Error("Expected series to be over once a player won most rounds")
+	}
+
+	score := lastMessage(p2).Payload.(protocol.Series)
+	if !score.Over || score.Winner != 1 || score.Round != 3 {
+		t.Errorf("Expected player 1 to win in round 3, got %v", score)
+	}
+}
+
+func TestSeriesTiedAfterAllRounds(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	series := NewSeries(NewSockets([]*Socket{p1, p2}), GameRules{}, 2)
+	series.Record(p1)
+
+	if !series.Record(p2) {
+		t.Fatal("Expected series to be over after its rounds")
+	}
+	if score := lastMessage(p1).Payload.(protocol.Series); score.Winner != 0 {
+		t.Errorf("Expected tied series, got winner %d", score.Winner)
+	}
+}
+
+func TestBestOfThreeAndRematch(t *testing.T) {
+	config := testConfig()
+	config.BestOf = 3
+	gameManager := NewGameManager(config)
+
+	server := NewServer(config, []EventHandler{
+		gameManager,
+		NewQueueManager(config),
+		NewMatchMaker(config),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+	gameId := startGame(c1, c2)
+
+	for round := 1; round <= 2; round++ {
+		gameManager.Games[gameId].Answer = 40
+
+		c1.Guess(40, gameId) // victory
+		c2.GetIncoming()     // loss
+		c1.GetIncoming()     // standings
+		c2.GetIncoming()     // standings
+
+		score := c1.GetIncoming()
+		c2.GetIncoming() // series_score
+
+		if score.Type != "series_score" {
+			t.Fatalf("Expected \"series_score\", got \"%s\"", score.Type)
+		}
+		if score.Payload.(*protocol.Series).Over != (round == 2) {
+			t.Fatalf("Expected series to be over after 2 rounds, got %v", score.Payload)
+		}
+
+		if round == 1 {
+			next := c1.GetIncoming()
+			c2.GetIncoming() // guess
+
+			if next.Type != "guess" {
+				t.Fatalf("Expected next round to start, got \"%s\"", next.Type)
+			}
+			gameId = next.Payload.(*protocol.GameStart).GameId
+		}
+	}
+
+	offer := c1.GetIncoming()
+	c2.GetIncoming() // rematch_offer
+
+	if offer.Type != "rematch_offer" {
+		t.Fatalf("Expected \"rematch_offer\", got \"%s\"", offer.Type)
+	}
+	rematchId := offer.Payload.(*protocol.RematchOffer).RematchId
+
+	for _, c := range []*TestClient{c1, c2} {
+		c.Client.Send(client.Message{
+			Type:    "rematch_accepted",
+			Payload: protocol.RematchAccepted{RematchId: rematchId},
+		})
+	}
+
+	if res := c1.GetIncoming(); res.Type != "guess" {
+		t.Errorf("Expected rematch to start a game, got \"%s\"", res.Type)
+	}
+}
+
+func TestRematchDeclined(t *testing.T) {
+	gameManager := NewGameManager(testConfig())
+
+	server := NewServer(testConfig(), []EventHandler{
+		gameManager,
+		NewQueueManager(testConfig()),
+		NewMatchMaker(testConfig()),
+	})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1 := NewTestClient()
+	c2 := NewTestClient()
+	gameId := startGame(c1, c2)
+	gameManager.Games[gameId].Answer = 40
+
+	c1.Guess(40, gameId) // victory
+	c1.GetIncoming()     // standings
+
+	offer := c1.GetIncoming()
+	if offer.Type != "rematch_offer" {
+		t.Fatalf("Expected \"rematch_offer\", got \"%s\"", offer.Type)
+	}
+
+	c2.Client.Send(client.Message{
+		Type:    "rematch_declined",
+		Payload: protocol.RematchDeclined{RematchId: offer.Payload.(*protocol.RematchOffer).RematchId},
+	})
+
+	if res := c1.GetIncoming(); res.Type != "rematch_canceled" {
+		t.Errorf("Expected \"rematch_canceled\", got \"%s\"", res.Type)
+	}
+}
+
+func TestGameEndsOnlyOnce(t *testing.T) {
+	config := testConfig()
+	gameManager := NewGameManager(config)
+
+	server := NewServer(config, []EventHandler{gameManager})
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(time.Millisecond)
+
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	series := NewSeries(NewSockets([]*Socket{p1, p2}), config.GameRules(), 3)
+	game := gameManager.StartRound(series, server)
+
+	// e.g. a late guess and the time limit both ending it
+	gameManager.EndGame(game, server)
+	gameManager.EndGame(game, server)
+
+	if series.Round != 1 {
+		t.Errorf("Expected the round to count once, got %d rounds", series.Round)
+	}
+	if pending := gameManager.Pending(); pending != 1 {
+		t.Errorf("Expected only the next round to start, got %d games", pending)
+	}
+}
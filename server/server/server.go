@@ -3,36 +3,151 @@ package server
 import (
 	"context"
 	"net/http"
+	"sync"
+	"time"
 
+	"example.com/game/client/protocol"
 	"github.com/gorilla/websocket"
 )
 
-type Message struct {
-	Type    string
-	Payload map[string]interface{}
-}
+const DRAIN_POLL_INTERVAL = 50 * time.Millisecond
+
+type Message = protocol.Message
 
 type Server struct {
-	server   *http.Server
-	handlers []EventHandler
+	config     Config
+	server     *http.Server
+	dispatcher *Dispatcher
+	sessions   *SessionManager
+
+	mutex    *sync.Mutex
+	draining bool
+	closed   bool
+}
+
+func NewServer(config Config, handlers []EventHandler) *Server {
+	server := &Server{
+		config:     config,
+		dispatcher: NewDispatcher(handlers, config.Workers, config.QueueSize),
+		sessions:   NewSessionManager(config.GracePeriod),
+		mutex:      new(sync.Mutex),
+	}
+
+	server.dispatcher.Start(server)
+	return server
 }
 
-func NewServer(handlers []EventHandler) *Server {
-	return &Server{
-		handlers: handlers,
+// Serves until the server is closed, returning http.ErrServerClosed after
+// Close or Shutdown and any other error if it could not listen at all
+func (s *Server) Listen() error {
+	tlsConfig, err := s.config.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      s.config.Addr,
+		Handler:   http.HandlerFunc(s.HandleRequest),
+		TLSConfig: tlsConfig,
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return http.ErrServerClosed
 	}
+	s.server = server
+	s.mutex.Unlock()
+
+	if tlsConfig != nil {
+		Infof("Listening on %s with TLS", s.config.Addr)
+		return server.ListenAndServeTLS("", "")
+	}
+
+	Infof("Listening on %s", s.config.Addr)
+	return server.ListenAndServe()
+}
+
+// Stops listening, if the server ever did, and keeps Listen from starting
+// afterwards
+func (s *Server) stopListening() error {
+	s.mutex.Lock()
+	s.closed = true
+	server := s.server
+	s.mutex.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(context.Background())
+}
+
+func (s *Server) Draining() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.draining
+}
+
+// Drains the server before closing it: queueing up is refused, every player
+// is told the server is going away and running games get until timeout to
+// finish. Whatever is left is then aborted and all connections are closed.
+func (s *Server) Shutdown(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	Infof("Draining until %s", deadline.Format(time.RFC3339))
+
+	s.mutex.Lock()
+	s.draining = true
+	s.mutex.Unlock()
+
+	s.Dispatch(Event{Type: "draining"})
+
+	s.sessions.Broadcast(Message{
+		Type: "server_shutting_down",
+		Payload: protocol.ServerShuttingDown{
+			Deadline: deadline,
+		},
+	})
+
+	for s.pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(DRAIN_POLL_INTERVAL)
+	}
+
+	s.Dispatch(Event{Type: "shutdown"})
+	s.dispatcher.Close()
+
+	s.sessions.Shutdown(websocket.CloseGoingAway, "server shutting down")
+
+	return s.stopListening()
 }
 
-func (s *Server) Listen(addr string) {
-	s.server = &http.Server{Addr: addr, Handler: http.HandlerFunc(s.HandleRequest)}
-	s.server.ListenAndServe()
+// Sums up what the handlers are still waiting on
+func (s *Server) pending() int {
+	total := 0
+
+	for _, handler := range s.dispatcher.handlers {
+		if drainer, ok := handler.(Drainer); ok {
+			total += drainer.Pending()
+		}
+	}
+
+	return total
 }
 
 func (s *Server) Close() {
-	s.server.Shutdown(context.Background())
+	s.stopListening()
+	s.dispatcher.Close()
 }
 
 func (s *Server) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("session")
+
+	// players who were cut off may come back to finish their game
+	if s.Draining() && !s.sessions.Has(token) {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	upgrader := websocket.Upgrader{}
 	connection, err := upgrader.Upgrade(w, r, nil)
 
@@ -40,30 +155,102 @@ func (s *Server) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	socket := NewSocket(connection)
+	session, resumed := s.sessions.Resume(token, connection)
+
+	if !resumed {
+		// the session expired while upgrading
+		if s.Draining() {
+			connection.Close()
+			return
+		}
+		session = s.sessions.Open(connection)
+	}
+
+	socket := session.Socket
+	socket.SetWriteWait(s.config.Keepalive.WriteWait)
+	socket.SetSendQueue(s.config.SendQueue, s.config.Overflow)
+
+	// set before reading starts, since the reader calls the pong handler
+	keepalive := s.config.Keepalive
+	connection.SetReadDeadline(keepalive.ReadDeadline())
+	connection.SetPongHandler(func(string) error {
+		return connection.SetReadDeadline(keepalive.ReadDeadline())
+	})
+
+	done := make(chan bool)
+	go s.heartbeat(connection, done)
 
 	go func() {
-		defer socket.Close()
+		defer connection.Close()
+		defer close(done)
 
 		for {
-			var msg Message
-			err := connection.ReadJSON(&msg)
+			_, data, err := connection.ReadMessage()
 
 			if err != nil {
-				s.Dispatch(Event{
-					Socket: socket,
-					Type:   "disconnected",
-				})
+				disconnect := func() {
+					socket.End()
+					s.Dispatch(Event{
+						Socket: socket,
+						Type:   "disconnected",
+					})
+				}
+
+				// a client closing on purpose won't come back, and neither
+				// does one that was too slow to keep up
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) || socket.Gone() {
+					if s.sessions.End(session, connection) {
+						disconnect()
+					}
+				} else {
+					s.sessions.Suspend(session, connection, disconnect)
+				}
 				break
 			}
 
-			s.Dispatch(NewEvent(msg, socket))
+			connection.SetReadDeadline(s.config.Keepalive.ReadDeadline())
+			msg, err := protocol.Decode(data, protocol.ToServer)
+
+			if err != nil {
+				socket.Send(Message{
+					Type:      "error",
+					Payload:   err,
+					RequestId: msg.RequestId,
+				})
+				continue
+			}
+
+			s.dispatcher.Submit(NewEvent(msg, socket))
 		}
 	}()
 }
 
-func (s *Server) Dispatch(event Event) {
-	for _, handler := range s.handlers {
-		handler.Process(event, s)
+// Pings the peer until done is closed. A peer that neither answers nor sends
+// anything within the pong wait makes the read fail with a timeout, which is
+// handled as a dropped connection.
+func (s *Server) heartbeat(connection *websocket.Conn, done chan bool) {
+	keepalive := s.config.Keepalive
+
+	ticker := time.NewTicker(keepalive.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			connection.WriteControl(websocket.PingMessage, nil, keepalive.WriteDeadline())
+		case <-done:
+			return
+		}
 	}
 }
+
+// Finds a connected or suspended player by their public ID
+func (s *Server) FindPlayer(playerId string) *Socket {
+	return s.sessions.FindPlayer(playerId)
+}
+
+// Queues the event for the handlers without blocking, so it is safe to
+// call from within EventHandler.Process
+func (s *Server) Dispatch(event Event) {
+	s.dispatcher.Dispatch(event)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"example.com/game/client/protocol"
+	"github.com/gorilla/websocket"
+)
+
+type Session struct {
+	Token  string
+	Socket *Socket
+
+	// bumped on every suspension so a stale timer can tell it was superseded
+	suspensions int
+	timer       *time.Timer
+}
+
+// SessionManager keeps a player's seat for a grace period after the
+// connection drops, so a new connection can resume it with the token
+// issued on connect.
+type SessionManager struct {
+	mutex    *sync.Mutex
+	grace    time.Duration
+	sessions map[string]*Session
+}
+
+func NewSessionManager(grace time.Duration) *SessionManager {
+	return &SessionManager{
+		mutex:    new(sync.Mutex),
+		grace:    grace,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Starts a new session for the connection
+func (m *SessionManager) Open(conn *websocket.Conn) *Session {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session := &Session{
+		Token:  NewToken(),
+		Socket: NewSocket(conn),
+	}
+	m.sessions[session.Token] = session
+
+	session.Socket.Send(Message{
+		Type: "session",
+		Payload: protocol.Session{
+			Token:    session.Token,
+			PlayerId: session.Socket.PlayerId(),
+		},
+	})
+
+	return session
+}
+
+// Tells whether a connection with the given token would resume a session
+func (m *SessionManager) Has(token string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, ok := m.sessions[token]
+	return ok
+}
+
+// Attaches the connection to the session with the given token, replaying
+// the messages it missed. Returns false if there is no such session.
+func (m *SessionManager) Resume(token string, conn *websocket.Conn) (*Session, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session, ok := m.sessions[token]
+	if !ok {
+		return nil, false
+	}
+
+	if session.timer != nil {
+		session.timer.Stop()
+		session.timer = nil
+	}
+
+	previous := session.Socket.Attach(conn, Message{
+		Type: "session",
+		Payload: protocol.Session{
+			Token:    session.Token,
+			PlayerId: session.Socket.PlayerId(),
+			Resumed:  true,
+		},
+	})
+
+	if previous != conn {
+		previous.Close()
+	}
+
+	return session, true
+}
+
+// Detaches the connection from the session and calls expire once the grace
+// period is over without the session being resumed. Does nothing if conn
+// was already replaced by a resumed connection.
+func (m *SessionManager) Suspend(session *Session, conn *websocket.Conn, expire func()) {
+	m.mutex.Lock()
+
+	if !session.Socket.Detach(conn) {
+		m.mutex.Unlock()
+		return
+	}
+
+	if m.grace <= 0 {
+		delete(m.sessions, session.Token)
+		m.mutex.Unlock()
+		expire()
+		return
+	}
+
+	session.suspensions++
+	suspension := session.suspensions
+
+	session.timer = time.AfterFunc(m.grace, func() {
+		m.mutex.Lock()
+
+		if m.sessions[session.Token] != session || session.suspensions != suspension || !session.Socket.Detached() {
+			m.mutex.Unlock()
+			return
+		}
+
+		delete(m.sessions, session.Token)
+		m.mutex.Unlock()
+		expire()
+	})
+
+	m.mutex.Unlock()
+}
+
+// Ends the session right away, e.g. when the client closed it on purpose.
+// Returns false if conn was already replaced by a resumed connection.
+func (m *SessionManager) End(session *Session, conn *websocket.Conn) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !session.Socket.Detach(conn) {
+		return false
+	}
+
+	if session.timer != nil {
+		session.timer.Stop()
+	}
+
+	delete(m.sessions, session.Token)
+	return true
+}
+
+// Finds the seat of the player with the given public ID, nil if they have
+// no session
+func (m *SessionManager) FindPlayer(playerId string) *Socket {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, session := range m.sessions {
+		if session.Socket.PlayerId() == playerId {
+			return session.Socket
+		}
+	}
+	return nil
+}
+
+// Sends the message to every session, resumed or not
+func (m *SessionManager) Broadcast(msg Message) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, session := range m.sessions {
+		session.Socket.Send(msg)
+	}
+}
+
+// Closes every connection with the given close frame and forgets
+// all sessions
+func (m *SessionManager) Shutdown(code int, reason string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for token, session := range m.sessions {
+		if session.timer != nil {
+			session.timer.Stop()
+		}
+
+		session.Socket.Shutdown(code, reason)
+		delete(m.sessions, token)
+	}
+}
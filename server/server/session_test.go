@@ -0,0 +1,168 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"example.com/game/client/client"
+	"example.com/game/client/protocol"
+	"github.com/gorilla/websocket"
+)
+
+type RawClient struct {
+	conn  *websocket.Conn
+	Token string
+}
+
+func NewRawClient(query string) (*RawClient, protocol.Session) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://0.0.0.0:8080/"+query, nil)
+	if err != nil {
+		return nil, protocol.Session{}
+	}
+
+	client := &RawClient{conn: conn}
+	session := client.Read().Payload.(*protocol.Session)
+	client.Token = session.Token
+
+	return client, *session
+}
+
+func (c *RawClient) Read() Message {
+	_, data, _ := c.conn.ReadMessage()
+	msg, _ := protocol.Decode(data, protocol.ToClient)
+
+	return msg
+}
+
+func (c *RawClient) Write(msg Message) {
+	c.conn.WriteJSON(msg)
+}
+
+// Drops the connection without a close frame, like a network failure
+func (c *RawClient) Drop() {
+	c.conn.Close()
+}
+
+func TestResumeKeepsSeatAndReplaysMessages(t *testing.T) {
+	queueManager := NewQueueManager(testConfig())
+
+	config := testConfig()
+	config.GracePeriod = time.Second
+	config.ConfirmationTimeout = time.Second
+
+	server := NewServer(config, []EventHandler{
+		queueManager,
+		NewMatchMaker(config),
+	})
+
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c1, _ := NewRawClient("")
+	c1.Write(Message{Type: "queue_up"})
+	c1.Read() // wait_for_match
+
+	c1.Drop()
+	time.Sleep(10 * time.Millisecond)
+
+	if queueManager.Count() != 1 {
+		t.Errorf("Expected dropped player to keep its place, got %d", queueManager.Count())
+	}
+
+	c2 := NewTestClient()
+	c2.QueueUp()
+
+	time.Sleep(10 * time.Millisecond)
+
+	resumed, session := NewRawClient("?session=" + c1.Token)
+	defer resumed.Drop()
+
+	if !session.Resumed {
+		t.Fatal("Expected session to be resumed")
+	}
+
+	res := resumed.Read()
+	if res.Type != "match_found" {
+		t.Errorf("Expected missed \"match_found\", got \"%s\"", res.Type)
+	}
+}
+
+func TestGracePeriodExpires(t *testing.T) {
+	queueManager := NewQueueManager(testConfig())
+
+	config := testConfig()
+	config.GracePeriod = 50 * time.Millisecond
+
+	server := NewServer(config, []EventHandler{queueManager})
+
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c, _ := NewRawClient("")
+	c.Write(Message{Type: "queue_up"})
+	c.Read() // wait_for_match
+
+	c.Drop()
+	time.Sleep(100 * time.Millisecond)
+
+	if queueManager.Count() != 0 {
+		t.Errorf("Expected player to be dequeued, got %d", queueManager.Count())
+	}
+
+	resumed, session := NewRawClient("?session=" + c.Token)
+	defer resumed.Drop()
+
+	if session.Resumed {
+		t.Error("Expected expired session to not be resumed")
+	}
+	if session.Token == c.Token {
+		t.Error("Expected a new session token")
+	}
+}
+
+func TestClientResumesAfterDrop(t *testing.T) {
+	queueManager := NewQueueManager(testConfig())
+
+	config := testConfig()
+	config.GracePeriod = 5 * time.Second
+
+	server := NewServer(config, []EventHandler{queueManager})
+
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := NewTestClient()
+	c.QueueUp()
+
+	// drop the TCP connection from the server's end, without a close frame
+	socket := server.FindPlayer(c.Client.PlayerId)
+	socket.mutex.Lock()
+	conn := socket.conn
+	socket.mutex.Unlock()
+	conn.UnderlyingConn().Close()
+
+	time.Sleep(client.RECONNECT_DELAY + 500*time.Millisecond)
+	c.Client.Send(Message{Type: "list_queues"})
+
+	resumed := make(chan bool)
+	go func() {
+		waitFor(c, "queues")
+		close(resumed)
+	}()
+
+	select {
+	case <-resumed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the client to resume its session")
+	}
+
+	if !c.Client.Running() || queueManager.Count() != 1 {
+		t.Errorf("Expected the client to keep its place, got %d waiting", queueManager.Count())
+	}
+}
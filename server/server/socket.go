@@ -0,0 +1,346 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/game/client/protocol"
+	"github.com/gorilla/websocket"
+)
+
+var socketIds uint64
+
+const (
+	MAX_MISSED_MESSAGES = 64
+	DEFAULT_SEND_QUEUE  = 32
+)
+
+// What a socket does when its outbound queue is full
+type OverflowPolicy int
+
+const (
+	// Discards the oldest queued message to make room
+	DROP_OLDEST OverflowPolicy = iota
+	// Ends the session and closes the connection, which is then handled as
+	// a disconnect
+	DISCONNECT_SLOW
+)
+
+// Socket is a player's seat on the server. The underlying connection may be
+// swapped when a dropped session is resumed, so queues, matches and games
+// keep referring to the same Socket.
+//
+// Messages are queued and written by a goroutine of their own, so a slow
+// client never blocks whoever is sending to it. A failed write closes the
+// connection, which the read loop reports as a disconnect.
+type Socket struct {
+	id    uint64
+	conn  *websocket.Conn
+	mutex *sync.Mutex
+
+	// public ID other players know the player by
+	playerId string
+
+	pending []Message
+	// messages at the head of pending that were missed while detached
+	replaying int
+	writing   bool
+	detached  bool
+	gone      bool
+	writeWait time.Duration
+	capacity  int
+	policy    OverflowPolicy
+
+	// close frame written once the queue is flushed
+	closing []byte
+
+	// messages of bot players, who have no connection
+	inbox chan Message
+}
+
+func NewSocket(conn *websocket.Conn) *Socket {
+	return &Socket{
+		id:    atomic.AddUint64(&socketIds, 1),
+		conn:  conn,
+		mutex: new(sync.Mutex),
+
+		playerId: NewId(),
+
+		writeWait: protocol.DefaultKeepalive().WriteWait,
+		capacity:  DEFAULT_SEND_QUEUE,
+		policy:    DROP_OLDEST,
+	}
+}
+
+// Creates the seat of a bot player. Messages sent to it are delivered to
+// Inbox instead of a connection.
+func NewBotSocket() *Socket {
+	socket := NewSocket(nil)
+	socket.inbox = make(chan Message, DEFAULT_SEND_QUEUE)
+
+	return socket
+}
+
+func (s *Socket) PlayerId() string {
+	return s.playerId
+}
+
+func (s *Socket) Bot() bool {
+	return s.inbox != nil
+}
+
+func (s *Socket) Inbox() <-chan Message {
+	return s.inbox
+}
+
+// Sets how long a single write may take before the connection is
+// considered broken
+func (s *Socket) SetWriteWait(writeWait time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.writeWait = writeWait
+}
+
+// Sets how many messages may wait to be written and what happens
+// when there are more
+func (s *Socket) SetSendQueue(capacity int, policy OverflowPolicy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.capacity = capacity
+	s.policy = policy
+}
+
+// Queues the message. While the connection is gone messages are kept for
+// replay when the session is resumed.
+func (s *Socket) Send(msg Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closing != nil {
+		return
+	}
+
+	if s.inbox != nil {
+		select {
+		case s.inbox <- msg:
+		default:
+			// the bot fell behind, it only misses stale updates
+		}
+		return
+	}
+
+	if s.detached {
+		if len(s.pending) >= MAX_MISSED_MESSAGES {
+			s.pending = s.pending[1:]
+		}
+		s.pending = append(s.pending, msg)
+		return
+	}
+
+	// the replayed messages don't count, or a resumed session with a
+	// long backlog would overflow right away
+	if len(s.pending)-s.replaying >= s.capacity {
+		switch s.policy {
+		case DROP_OLDEST:
+			s.pending = append(s.pending[:s.replaying], s.pending[s.replaying+1:]...)
+		case DISCONNECT_SLOW:
+			// a slow client won't catch up by resuming
+			s.gone = true
+			s.conn.Close()
+			return
+		}
+	}
+
+	s.pending = append(s.pending, msg)
+	s.flush()
+}
+
+// Starts the writer if it is not running. Must hold the mutex.
+func (s *Socket) flush() {
+	if s.writing || s.detached || s.inbox != nil || (len(s.pending) == 0 && s.closing == nil) {
+		return
+	}
+
+	s.writing = true
+	go s.write(s.conn)
+}
+
+func (s *Socket) write(conn *websocket.Conn) {
+	for {
+		s.mutex.Lock()
+
+		if s.conn != conn {
+			// a resumed connection has its own writer
+			s.mutex.Unlock()
+			return
+		}
+
+		if !s.detached && len(s.pending) == 0 && s.closing != nil {
+			closing := s.closing
+			deadline := time.Now().Add(s.writeWait)
+			s.mutex.Unlock()
+
+			conn.WriteControl(websocket.CloseMessage, closing, deadline)
+			conn.Close()
+			return
+		}
+
+		if s.detached || len(s.pending) == 0 {
+			s.writing = false
+			s.mutex.Unlock()
+			return
+		}
+
+		msg := s.pending[0]
+		s.pending = s.pending[1:]
+		if s.replaying > 0 {
+			s.replaying--
+		}
+		deadline := time.Now().Add(s.writeWait)
+
+		s.mutex.Unlock()
+
+		conn.SetWriteDeadline(deadline)
+
+		if err := conn.WriteJSON(msg); err != nil {
+			s.mutex.Lock()
+			if s.conn == conn {
+				// keep it for replay
+				s.pending = append([]Message{msg}, s.pending...)
+				s.writing = false
+			}
+			s.mutex.Unlock()
+
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Marks the socket as disconnected, unless conn was already replaced
+func (s *Socket) Detach(conn *websocket.Conn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn != conn {
+		return false
+	}
+
+	s.detached = true
+	return true
+}
+
+func (s *Socket) Detached() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.detached
+}
+
+// Marks the session of the socket as over for good, once it can't be
+// resumed anymore
+func (s *Socket) End() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.gone = true
+}
+
+func (s *Socket) Gone() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.gone
+}
+
+// Replaces the connection, sending first and then the messages missed in
+// the meantime. Returns the previous connection.
+func (s *Socket) Attach(conn *websocket.Conn, first Message) *websocket.Conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous := s.conn
+
+	s.conn = conn
+	s.detached = false
+	s.writing = false
+	s.pending = append([]Message{first}, s.pending...)
+	s.replaying = len(s.pending)
+	s.flush()
+
+	return previous
+}
+
+// Writes the queued messages and then closes the connection with a close
+// frame, so the client knows it should not try to resume the session
+func (s *Socket) Shutdown(code int, reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closing != nil {
+		return
+	}
+
+	s.closing = websocket.FormatCloseMessage(code, reason)
+	s.flush()
+}
+
+func (s *Socket) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+type Sockets struct {
+	conns []*Socket
+	mutex *sync.Mutex
+}
+
+func NewSockets(sockets []*Socket) *Sockets {
+	return &Sockets{
+		conns: sockets,
+		mutex: new(sync.Mutex),
+	}
+}
+
+func (s *Sockets) Send(msg Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, socket := range s.conns {
+		socket.Send(msg)
+	}
+}
+
+func (s *Sockets) Add(socket *Socket) *Socket {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conns = append(s.conns, socket)
+	return socket
+}
+
+func (s *Sockets) Count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.conns)
+}
+
+func (s *Sockets) Has(conn *Socket) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, socket := range s.conns {
+		if socket == conn {
+			return true
+		}
+	}
+	return false
+}
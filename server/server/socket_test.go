@@ -0,0 +1,115 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/game/client/protocol"
+	"github.com/gorilla/websocket"
+)
+
+func TestSendQueueDropsOldest(t *testing.T) {
+	socket := NewSocket(&websocket.Conn{})
+	socket.SetSendQueue(2, DROP_OLDEST)
+
+	// pretend the writer is stuck on a slow client
+	socket.writing = true
+
+	for _, message := range []string{"first", "second", "third"} {
+		socket.Send(Message{Type: "feedback", Payload: protocol.Feedback{Message: message}})
+	}
+
+	if len(socket.pending) != 2 {
+		t.Fatalf("Expected 2 pending messages, got %d", len(socket.pending))
+	}
+	if socket.pending[0].Payload.(protocol.Feedback).Message != "second" {
+		t.Errorf("Expected oldest message to be dropped, got %v", socket.pending[0].Payload)
+	}
+}
+
+type Flooder struct{}
+
+func (f *Flooder) Process(event Event, server *Server) {
+	if event.Type == "queue_up" {
+		for i := 0; i < 100; i++ {
+			event.Socket.Send(Message{
+				Type:    "feedback",
+				Payload: protocol.Feedback{Message: strings.Repeat("x", 64*1024)},
+			})
+		}
+	}
+}
+
+func TestSlowConsumerIsDisconnected(t *testing.T) {
+	queueManager := NewQueueManager(testConfig())
+
+	config := testConfig()
+	config.SendQueue = 2
+	config.Overflow = DISCONNECT_SLOW
+	// the session isn't kept for resuming
+	config.GracePeriod = time.Second
+
+	server := NewServer(config, []EventHandler{queueManager, &Flooder{}})
+
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// never reads the flood
+	c, _ := NewRawClient("")
+	defer c.Drop()
+
+	c.Write(Message{Type: "queue_up"})
+
+	time.Sleep(100 * time.Millisecond)
+
+	if queueManager.Count() != 0 {
+		t.Errorf("Expected slow consumer to be disconnected, got %d queued", queueManager.Count())
+	}
+}
+
+func TestResumedBacklogDoesNotOverflow(t *testing.T) {
+	config := testConfig()
+	config.SendQueue = 2
+	config.Overflow = DISCONNECT_SLOW
+	config.GracePeriod = time.Second
+
+	server := NewServer(config, []EventHandler{})
+
+	defer server.Close()
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c, session := NewRawClient("")
+	socket := server.sessions.FindPlayer(session.PlayerId)
+
+	c.Drop()
+	time.Sleep(10 * time.Millisecond)
+
+	// big enough that the writer is still busy replaying once resumed
+	for i := 0; i < MAX_MISSED_MESSAGES; i++ {
+		socket.Send(Message{
+			Type:    "feedback",
+			Payload: protocol.Feedback{Message: strings.Repeat("x", 64*1024)},
+		})
+	}
+
+	resumed, _ := NewRawClient("?session=" + c.Token)
+	defer resumed.Drop()
+
+	socket.Send(Message{Type: "feedback", Payload: protocol.Feedback{Message: "last"}})
+
+	for i := 0; i < MAX_MISSED_MESSAGES; i++ {
+		resumed.Read()
+	}
+
+	if res := resumed.Read(); res.Type != "feedback" || res.Payload.(*protocol.Feedback).Message != "last" {
+		t.Errorf("Expected the whole backlog and the new message, got \"%s\"", res.Type)
+	}
+	if socket.Gone() {
+		t.Error("Expected resumed socket to be kept")
+	}
+}
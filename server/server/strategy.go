@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	FIFO_STRATEGY   = "fifo"
+	RATING_STRATEGY = "rating"
+
+	// how often waiting players are matched again while their window widens
+	SEARCH_INTERVAL = time.Second
+)
+
+// A player, or a party, waiting in a queue. Parties are rated by the
+// average of their members.
+type Waiting struct {
+	Players []*Socket
+	Rating  Rating
+	Since   time.Time
+}
+
+// Strategy decides who is matched with whom among the players waiting,
+// given in the order they queued up. Parties are never split.
+type Strategy interface {
+	// Picks count players for a match, nil if no match can be made yet
+	Match(waiting []Waiting, count int) []*Socket
+	// Whether a match that can't be made now may be made later, so the
+	// queue needs to try again
+	Widens() bool
+}
+
+func NewStrategy(name string, config Config) (Strategy, error) {
+	switch name {
+	case FIFO_STRATEGY:
+		return &FifoStrategy{}, nil
+	case RATING_STRATEGY:
+		return &RatingStrategy{
+			Window:    config.MatchWindow,
+			Growth:    config.MatchWindowGrowth,
+			MaxWindow: config.MaxMatchWindow,
+		}, nil
+	}
+	return nil, errors.New("expected fifo or rating")
+}
+
+// FifoStrategy matches whoever queued up first, skipping parties too big
+// for the seats left
+type FifoStrategy struct{}
+
+func (s *FifoStrategy) Match(waiting []Waiting, count int) []*Socket {
+	players := make([]*Socket, 0, count)
+
+	for _, w := range waiting {
+		if len(players)+len(w.Players) <= count {
+			players = append(players, w.Players...)
+		}
+		if len(players) == count {
+			return players
+		}
+	}
+	return nil
+}
+
+func (s *FifoStrategy) Widens() bool {
+	return false
+}
+
+// RatingStrategy only matches players whose ratings are within each
+// other's window. Windows start at Window and widen by Growth every second
+// in queue, up to MaxWindow, so new players don't face veterans unless
+// they have waited a while.
+type RatingStrategy struct {
+	Window    float64
+	Growth    float64
+	MaxWindow float64
+}
+
+func (s *RatingStrategy) window(w Waiting, now time.Time) float64 {
+	window := s.Window + s.Growth*now.Sub(w.Since).Seconds()
+	return math.Min(window, s.MaxWindow)
+}
+
+// Tells whether a and b are within each other's window
+func (s *RatingStrategy) fits(a Waiting, b Waiting, now time.Time) bool {
+	diff := math.Abs(a.Rating.Rating - b.Rating.Rating)
+	return diff <= s.window(a, now) && diff <= s.window(b, now)
+}
+
+// Tries each player in queue order as the anchor of a match, so those who
+// waited longest are matched first. Everyone in a match fits the window
+// of everyone else, not only the anchor's.
+func (s *RatingStrategy) Match(waiting []Waiting, count int) []*Socket {
+	now := time.Now()
+
+	for i, anchor := range waiting {
+		players := append([]*Socket{}, anchor.Players...)
+		if len(players) == count {
+			return players
+		}
+
+		group := []Waiting{anchor}
+
+		for j, other := range waiting {
+			if i == j || len(players)+len(other.Players) > count {
+				continue
+			}
+
+			fits := true
+			for _, member := range group {
+				fits = fits && s.fits(member, other, now)
+			}
+
+			if fits {
+				group = append(group, other)
+				players = append(players, other.Players...)
+			}
+
+			if len(players) == count {
+				return players
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *RatingStrategy) Widens() bool {
+	return s.Growth > 0 && s.MaxWindow > s.Window
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+type FixedRatings map[*Socket]float64
+
+func (r FixedRatings) Rating(socket *Socket) Rating {
+	rating := NewRating()
+	rating.Rating = r[socket]
+	return rating
+}
+
+func waitingFor(since time.Time, ratings ...float64) []Waiting {
+	waiting := make([]Waiting, 0, len(ratings))
+
+	for _, rating := range ratings {
+		waiting = append(waiting, Waiting{
+			Players: []*Socket{newOfflineSocket()},
+			Rating:  Rating{Rating: rating},
+			Since:   since,
+		})
+	}
+	return waiting
+}
+
+func TestFifoStrategy(t *testing.T) {
+	waiting := waitingFor(time.Now(), 1000, 2000, 1500)
+
+	players := (&FifoStrategy{}).Match(waiting, 2)
+
+	if len(players) != 2 || players[0] != waiting[0].Players[0] || players[1] != waiting[1].Players[0] {
+		t.Errorf("Expected the first two players, got %v", players)
+	}
+	if (&FifoStrategy{}).Match(waiting, 4) != nil {
+		t.Error("Expected no match without enough players")
+	}
+}
+
+func TestRatingStrategyWindow(t *testing.T) {
+	strategy := &RatingStrategy{Window: 100, Growth: 10, MaxWindow: 500}
+	waiting := waitingFor(time.Now(), 1000, 2000, 1050)
+
+	players := strategy.Match(waiting, 2)
+
+	if len(players) != 2 || players[0] != waiting[0].Players[0] || players[1] != waiting[2].Players[0] {
+		t.Errorf("Expected the players of similar rating, got %v", players)
+	}
+	if strategy.Match(waiting[:2], 2) != nil {
+		t.Error("Expected players too far apart not to be matched")
+	}
+}
+
+func TestRatingStrategyFitsEveryPair(t *testing.T) {
+	strategy := &RatingStrategy{Window: 100, Growth: 10, MaxWindow: 500}
+
+	// both fit the anchor's window, but not each other's
+	waiting := waitingFor(time.Now(), 1000, 920, 1080)
+	if players := strategy.Match(waiting, 3); players != nil {
+		t.Errorf("Expected players too far apart not to be matched, got %v", players)
+	}
+
+	waiting = waitingFor(time.Now(), 1000, 920, 1020)
+	if players := strategy.Match(waiting, 3); len(players) != 3 {
+		t.Errorf("Expected the 3 players to be matched, got %v", players)
+	}
+}
+
+func TestRatingStrategyWidens(t *testing.T) {
+	strategy := &RatingStrategy{Window: 100, Growth: 10, MaxWindow: 500}
+
+	waited := waitingFor(time.Now().Add(-30*time.Second), 1000, 1350)
+	if strategy.Match(waited, 2) == nil {
+		t.Error("Expected the windows to widen over time")
+	}
+
+	capped := waitingFor(time.Now().Add(-time.Hour), 1000, 1600)
+	if strategy.Match(capped, 2) != nil {
+		t.Error("Expected the windows to stop at the max")
+	}
+}
+
+func TestQueueMatchesByRating(t *testing.T) {
+	config := testConfig()
+	config.Matchmaking = RATING_STRATEGY
+	queues := NewQueueManager(config)
+
+	novice := newOfflineSocket()
+	veteran := newOfflineSocket()
+	other := newOfflineSocket()
+
+	queues.UseRatings(FixedRatings{novice: 1400, veteran: 2200, other: 1450})
+
+	if _, players, _ := queues.Enqueue(novice, ""); players != nil {
+		t.Errorf("Expected no match, got %v", players)
+	}
+	if _, players, _ := queues.Enqueue(veteran, ""); players != nil {
+		t.Errorf("Expected the veteran not to face the novice, got %v", players)
+	}
+
+	_, players, _ := queues.Enqueue(other, "")
+	if len(players) != 2 || players[0] != novice || players[1] != other {
+		t.Errorf("Expected the novice to face the other player, got %v", players)
+	}
+	if queues.Count() != 1 {
+		t.Errorf("Expected the veteran to keep waiting, got %d waiting", queues.Count())
+	}
+}
+
+func TestSearchMatchingOthersKeepsSearching(t *testing.T) {
+	config := testConfig()
+	config.Matchmaking = RATING_STRATEGY
+	queues := NewQueueManager(config)
+
+	low := newOfflineSocket()
+	high := newOfflineSocket()
+	veteran := newOfflineSocket()
+
+	queues.UseRatings(FixedRatings{low: 1000, high: 1150, veteran: 2200})
+
+	queues.Enqueue(low, "")
+	queues.Enqueue(high, "")
+	queues.Enqueue(veteran, "")
+
+	// their windows widened enough for each other
+	queues.joined[low] = time.Now().Add(-10 * time.Second)
+	queues.joined[high] = time.Now().Add(-10 * time.Second)
+
+	_, players, waiting := queues.Search(veteran)
+
+	if len(players) != 2 {
+		t.Errorf("Expected the other two to be matched, got %v", players)
+	}
+	if !waiting {
+		t.Error("Expected the veteran to keep searching")
+	}
+}
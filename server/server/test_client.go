@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"example.com/game/client/client"
+	"example.com/game/client/protocol"
 )
 
 type TestClient struct {
@@ -18,7 +19,13 @@ func NewTestClient() *TestClient {
 		Client: client.NewClient(),
 	}
 
-	testClient.Client.Connect("0.0.0.0:8080")
+	// the server may still be starting to listen
+	for i := 0; i < 100; i++ {
+		if err := testClient.Client.Connect("0.0.0.0:8080"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 	return testClient
 }
 
@@ -26,40 +33,57 @@ func (c *TestClient) GetIncoming() client.Message {
 	return <-c.Client.Incoming
 }
 
+// Reads the next message, expected to be match_found, and returns
+// the match ID
+func (c *TestClient) WaitForMatch() string {
+	msg := c.GetIncoming()
+
+	if found, ok := msg.Payload.(*protocol.MatchFound); ok {
+		return found.MatchId
+	}
+	return ""
+}
+
 func (c *TestClient) QueueUp() client.Message {
+	return c.QueueUpFor("")
+}
+
+// Queues up in the named queue, empty for the default one
+func (c *TestClient) QueueUpFor(queue string) client.Message {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	c.Client.Send(client.Message{
-		Type: "queue_up",
+		Type:    "queue_up",
+		Payload: protocol.QueueUp{Queue: queue},
 	})
 
 	return c.GetIncoming()
 }
 
-func (c *TestClient) Guess(guess string, gameId int) client.Message {
+func (c *TestClient) Guess(guess int, gameId string) client.Message {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	c.Client.Send(client.Message{
 		Type: "guess",
-		Payload: map[string]interface{}{
-			"guess":  guess,
-			"gameId": gameId,
+		Payload: protocol.Guess{
+			Guess:  guess,
+			GameId: gameId,
 		},
 	})
 
 	return c.GetIncoming()
 }
 
-func (c *TestClient) AcceptMatch(match int) client.Message {
+func (c *TestClient) AcceptMatch(match string) client.Message {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	c.Client.Send(client.Message{
 		Type: "match_confirmed",
-		Payload: map[string]interface{}{
-			"matchId": match,
+		Payload: protocol.MatchConfirmed{
+			MatchId: match,
 		},
 	})
 
@@ -67,16 +91,49 @@ func (c *TestClient) AcceptMatch(match int) client.Message {
 	return c.GetIncoming()
 }
 
-func (c *TestClient) DenyMatch(match int) client.Message {
+func (c *TestClient) DenyMatch(match string) client.Message {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	c.Client.Send(client.Message{
 		Type: "match_declined",
-		Payload: map[string]interface{}{
-			"matchId": match,
+		Payload: protocol.MatchDeclined{
+			MatchId: match,
 		},
 	})
 	time.Sleep(time.Millisecond)
 	return c.GetIncoming()
 }
+
+func (c *TestClient) ChooseSecret(secret int, gameId string) client.Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Client.Request(client.Message{
+		Type: "secret",
+		Payload: protocol.Secret{
+			Secret: secret,
+			GameId: gameId,
+		},
+	})
+
+	return c.GetIncoming()
+}
+
+// Asks for the player's rating, skipping messages until the profile arrives
+func (c *TestClient) Profile() *protocol.Profile {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Client.Send(client.Message{
+		Type:    "profile",
+		Payload: protocol.Empty{},
+	})
+
+	for {
+		msg := c.GetIncoming()
+		if profile, ok := msg.Payload.(*protocol.Profile); ok {
+			return profile
+		}
+	}
+}
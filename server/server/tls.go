@@ -0,0 +1,105 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// Hosts the self-signed certificate is valid for
+var SELF_SIGNED_HOSTS = []string{"localhost", "127.0.0.1", "::1"}
+
+// Generates a PEM encoded certificate and key valid for a year for the given
+// hosts. Meant for development only, clients have to trust it explicitly.
+func GenerateSelfSigned(hosts []string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Number Guesser Dev"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	return certPem, keyPem, nil
+}
+
+// Builds the TLS config from the configured files or, in development,
+// from a freshly generated self-signed certificate, written to
+// SelfSignedFile for clients to trust. Returns nil when TLS is disabled.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	var certificate tls.Certificate
+	var err error
+
+	switch {
+	case c.CertFile != "":
+		certificate, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	case c.SelfSigned:
+		var certPem, keyPem []byte
+
+		certPem, keyPem, err = GenerateSelfSigned(SELF_SIGNED_HOSTS)
+		if err == nil {
+			certificate, err = tls.X509KeyPair(certPem, keyPem)
+		}
+		// only the certificate, clients need it to trust the server
+		if err == nil {
+			err = os.WriteFile(c.SelfSignedFile, certPem, 0644)
+		}
+		if err == nil {
+			Infof("Wrote the self-signed certificate to %s, connect with -ca-file %s", c.SelfSignedFile, c.SelfSignedFile)
+		}
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/game/client/client"
+)
+
+func TestTLSWithCertificateFiles(t *testing.T) {
+	certPem, keyPem, err := GenerateSelfSigned(SELF_SIGNED_HOSTS)
+	if err != nil {
+		t.Fatalf("Expected certificate, got error: %v", err)
+	}
+
+	dir := t.TempDir()
+	config := testConfig()
+	config.Addr = "127.0.0.1:8080"
+	config.CertFile = filepath.Join(dir, "cert.pem")
+	config.KeyFile = filepath.Join(dir, "key.pem")
+
+	os.WriteFile(config.CertFile, certPem, 0644)
+	os.WriteFile(config.KeyFile, keyPem, 0600)
+
+	server := NewServer(config, []EventHandler{})
+	defer server.Close()
+
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	untrusted := client.NewClient()
+	untrusted.TLS, _ = client.NewTLSConfig("", false)
+
+	if err := untrusted.Connect("127.0.0.1:8080"); err == nil {
+		t.Error("Expected unknown certificate to be rejected")
+	}
+
+	trusted := client.NewClient()
+	trusted.TLS, err = client.NewTLSConfig(config.CertFile, false)
+	if err != nil {
+		t.Fatalf("Expected TLS config, got error: %v", err)
+	}
+
+	if err := trusted.Connect("127.0.0.1:8080"); err != nil {
+		t.Errorf("Expected connection, got error: %v", err)
+	}
+
+	plain := client.NewClient()
+	if err := plain.Connect("127.0.0.1:8080"); err == nil {
+		t.Error("Expected plain connection to be rejected")
+	}
+}
+
+func TestTLSWithSelfSignedCertificate(t *testing.T) {
+	config := testConfig()
+	config.SelfSigned = true
+	config.SelfSignedFile = filepath.Join(t.TempDir(), "cert.pem")
+
+	server := NewServer(config, []EventHandler{})
+	defer server.Close()
+
+	go server.Listen()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// trusts the written certificate instead of skipping verification
+	tlsConfig, err := client.NewTLSConfig(config.SelfSignedFile, false)
+	if err != nil {
+		t.Fatalf("Expected the certificate to be written, got %v", err)
+	}
+
+	c := client.NewClient()
+	c.TLS = tlsConfig
+
+	if err := c.Connect("127.0.0.1:8080"); err != nil {
+		t.Errorf("Expected connection, got error: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"example.com/game/client/protocol"
+)
+
+// Variant is the puzzle of a game: how secrets are drawn and how close a
+// guess gets to one. Guesses and secrets are both ints, the variant decides
+// what they mean.
+type Variant interface {
+	Draw(rules GameRules) int
+	// Tells why the guess can't be made under the rules, if it can't
+	Validate(guess int, rules GameRules) error
+	// Tells why a player can't pick the secret for an opponent, if they can't
+	ValidateSecret(secret int, rules GameRules) error
+	// Feedback for a wrong guess
+	Hint(guess int, answer int, rules GameRules) string
+}
+
+var variants = map[string]Variant{
+	protocol.HIGHER_OR_LOWER: HigherOrLower{},
+	protocol.BULLS_AND_COWS:  BullsAndCows{},
+}
+
+// Returns the variant of the rules, higher or lower by default
+func VariantFor(rules GameRules) Variant {
+	if variant, ok := variants[rules.Variant]; ok {
+		return variant
+	}
+	return HigherOrLower{}
+}
+
+type HigherOrLower struct{}
+
+func (HigherOrLower) Draw(rules GameRules) int {
+	return rules.Draw()
+}
+
+func (HigherOrLower) Validate(guess int, rules GameRules) error {
+	if guess < rules.Min || guess > rules.Max {
+		return fmt.Errorf("Guess must be between %d and %d", rules.Min, rules.Max)
+	}
+	return nil
+}
+
+func (h HigherOrLower) ValidateSecret(secret int, rules GameRules) error {
+	return h.Validate(secret, rules)
+}
+
+const (
+	GREATER_HINT = "Try a greater number"
+	SMALLER_HINT = "Try a smaller number"
+)
+
+func (HigherOrLower) Hint(guess int, answer int, rules GameRules) string {
+	if guess < answer {
+		return GREATER_HINT
+	}
+	return SMALLER_HINT
+}
+
+// BullsAndCows secrets are codes of distinct digits, possibly starting
+// with zeros, e.g. 0427 for 4 digits
+type BullsAndCows struct{}
+
+func (BullsAndCows) Draw(rules GameRules) int {
+	code := 0
+
+	for _, digit := range rand.Perm(10)[:rules.Digits] {
+		code = code*10 + digit
+	}
+	return code
+}
+
+func (BullsAndCows) Validate(guess int, rules GameRules) error {
+	if guess < 0 || len(rules.Format(guess)) != rules.Digits {
+		return fmt.Errorf("Guess must have %d digits", rules.Digits)
+	}
+	return nil
+}
+
+// Guesses may repeat digits to narrow them down, secrets can't
+func (BullsAndCows) ValidateSecret(secret int, rules GameRules) error {
+	code := rules.Format(secret)
+
+	if secret < 0 || len(code) != rules.Digits {
+		return fmt.Errorf("Secret must have %d digits", rules.Digits)
+	}
+	for i := range code {
+		if strings.IndexByte(code[i+1:], code[i]) >= 0 {
+			return fmt.Errorf("Secret must have %d different digits", rules.Digits)
+		}
+	}
+	return nil
+}
+
+func (BullsAndCows) Hint(guess int, answer int, rules GameRules) string {
+	bulls, cows := Score(rules.Format(guess), rules.Format(answer))
+	return fmt.Sprintf("%d %s, %d %s", bulls, plural(bulls, "bull"), cows, plural(cows, "cow"))
+}
+
+// Counts the digits of guess in the same place as in code (bulls) and the
+// ones present elsewhere (cows)
+func Score(guess string, code string) (int, int) {
+	bulls := 0
+	seen := make(map[byte]int)
+	guessed := make(map[byte]int)
+
+	for i := range code {
+		if guess[i] == code[i] {
+			bulls++
+		}
+		seen[code[i]]++
+		guessed[guess[i]]++
+	}
+
+	matches := 0
+	for digit, count := range guessed {
+		if seen[digit] < count {
+			count = seen[digit]
+		}
+		matches += count
+	}
+
+	return bulls, matches - bulls
+}
+
+func plural(count int, word string) string {
+	if count == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+
+	"example.com/game/client/protocol"
+)
+
+func TestScore(t *testing.T) {
+	cases := []struct {
+		guess string
+		code  string
+		bulls int
+		cows  int
+	}{
+		{"1234", "1234", 4, 0},
+		{"4321", "1234", 0, 4},
+		{"1243", "1234", 2, 2},
+		{"5678", "1234", 0, 0},
+		{"1111", "1234", 1, 0},
+		{"0912", "9021", 0, 4},
+	}
+
+	for _, c := range cases {
+		bulls, cows := Score(c.guess, c.code)
+
+		if bulls != c.bulls || cows != c.cows {
+			t.Errorf("%s against %s: expected %d bulls and %d cows, got %d and %d", c.guess, c.code, c.bulls, c.cows, bulls, cows)
+		}
+	}
+}
+
+func TestBullsAndCowsDrawsDistinctDigits(t *testing.T) {
+	rules := GameRules{Min: 0, Max: 99, Variant: protocol.BULLS_AND_COWS, Digits: 4}
+	variant := VariantFor(rules)
+
+	for i := 0; i < 100; i++ {
+		code := rules.Format(variant.Draw(rules))
+
+		if len(code) != 4 {
+			t.Fatalf("Expected 4 digits, got %s", code)
+		}
+
+		seen := make(map[rune]bool)
+		for _, digit := range code {
+			if seen[digit] {
+				t.Fatalf("Expected distinct digits, got %s", code)
+			}
+			seen[digit] = true
+		}
+	}
+}
+
+func TestBullsAndCowsSecretsHaveDistinctDigits(t *testing.T) {
+	rules := GameRules{Min: 0, Max: 99, Variant: protocol.BULLS_AND_COWS, Digits: 4}
+	variant := VariantFor(rules)
+
+	if err := variant.ValidateSecret(427, rules); err != nil {
+		t.Errorf("Expected 0427 to be a valid secret, got %v", err)
+	}
+	if err := variant.ValidateSecret(1123, rules); err == nil {
+		t.Error("Expected secret with repeated digits to be rejected")
+	}
+	if err := variant.Validate(1123, rules); err != nil {
+		t.Errorf("Expected guess with repeated digits to be valid, got %v", err)
+	}
+}
+
+func TestBullsAndCowsGame(t *testing.T) {
+	p1 := newOfflineSocket()
+	p2 := newOfflineSocket()
+
+	rules := GameRules{Min: 0, Max: 99, SharedAnswer: true, Variant: protocol.BULLS_AND_COWS, Digits: 4}
+	game := NewGame(NewSockets([]*Socket{p1, p2}), rules)
+	game.Answer = 427
+
+	if err := game.Variant.Validate(12345, rules); err == nil {
+		t.Error("Expected code of 5 digits to be invalid")
+	}
+	if err := game.Variant.Validate(724, rules); err != nil {
+		t.Errorf("Expected code with leading zero to be valid, got %v", err)
+	}
+
+	game.CheckGuess(724, p1)
+	if res := lastMessage(p1); res.Payload.(protocol.Feedback).Message != "2 bulls, 2 cows" {
+		t.Errorf("Expected \"2 bulls, 2 cows\", got \"%s\"", res.Payload.(protocol.Feedback).Message)
+	}
+
+	if !game.CheckGuess(427, p2) {
+		t.Fatal("Expected right code to end the game")
+	}
+	if res := lastResult(p1); res.Payload.(protocol.Loss).Message != "You lost. The answer was 0427" {
+		t.Errorf("Expected answer with leading zero, got \"%s\"", res.Payload.(protocol.Loss).Message)
+	}
+}